@@ -0,0 +1,362 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2025 Hajime Hoshi
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/mod/module"
+
+	"github.com/hajimehoshi/uwagaki"
+	"github.com/hajimehoshi/uwagaki/internal/diff"
+)
+
+// editTarget represents where an edited file is saved.
+type editTarget interface {
+	// current returns the current replacement of the file.
+	// current returns false if the file is not replaced.
+	current(orig []byte) (content []byte, ok bool, err error)
+
+	// save saves the edited content. If the content is the same as the original, the replacement is removed.
+	save(orig, content []byte) error
+}
+
+func runEdit(args []string, stdout, stderr io.Writer) error {
+	var patchDir, manifest string
+	var positional []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			positional = append(positional, args[i+1:]...)
+			break
+		}
+		name, value, hasValue, ok := flagName(arg)
+		if !ok {
+			positional = append(positional, arg)
+			continue
+		}
+		if name != "patchdir" && name != "manifest" {
+			return &usageError{command: "edit", err: fmt.Errorf("flag provided but not defined: %s", arg)}
+		}
+		if !hasValue {
+			if i+1 >= len(args) {
+				return &usageError{command: "edit", err: fmt.Errorf("flag needs an argument: %s", arg)}
+			}
+			i++
+			value = args[i]
+		}
+		if name == "patchdir" {
+			patchDir = value
+		} else {
+			manifest = value
+		}
+	}
+	if (patchDir == "") == (manifest == "") {
+		return &usageError{command: "edit", err: fmt.Errorf("exactly one of -patchdir and -manifest must be specified")}
+	}
+	if len(positional) != 2 {
+		return &usageError{command: "edit", err: fmt.Errorf("a module and a path must be specified")}
+	}
+
+	mod, version, _ := strings.Cut(positional[0], "@")
+	filePath := positional[1]
+	if err := (&uwagaki.ReplaceItem{Mod: mod, Path: filePath, Content: []byte{}}).Validate(); err != nil {
+		return err
+	}
+
+	m, dir, err := resolveEditModule(mod, version)
+	if err != nil {
+		return err
+	}
+	// The version is recorded only when it is specified explicitly.
+	if version != "" {
+		version = m.Version
+	}
+
+	orig, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(filePath)))
+	origExists := err == nil
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	var target editTarget
+	if patchDir != "" {
+		target = &patchDirTarget{dir: patchDir, mod: mod, version: version, path: filePath}
+	} else {
+		target = &manifestTarget{path: manifest, mod: mod, version: version, filePath: filePath}
+	}
+
+	content, ok, err := target.current(orig)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		content = orig
+	}
+
+	edited, err := editFile(path.Base(filePath), content, stderr)
+	if err != nil {
+		return err
+	}
+	if err := target.save(orig, edited); err != nil {
+		return err
+	}
+
+	name := mod
+	if m.Version != "" {
+		name += "@" + m.Version
+	}
+	oldName := "a/" + name + "/" + filePath
+	if !origExists {
+		oldName = diff.DevNull
+	}
+	newName := "b/" + name + "/" + filePath
+	if !origExists && len(edited) == 0 {
+		// An empty file for an absent file is regarded as not created, as the replacement is removed.
+		newName = diff.DevNull
+	}
+	d := diff.Unified(oldName, newName, orig, edited)
+	if d == nil {
+		fmt.Fprintf(stderr, "uwagaki edit: %s/%s is not changed from the original\n", name, filePath)
+		return nil
+	}
+	if _, err := stdout.Write(d); err != nil {
+		return err
+	}
+	return nil
+}
+
+// resolveEditModule returns the module and its directory.
+// If version is empty, the version in the build list of the current module is used.
+func resolveEditModule(mod, version string) (module.Version, string, error) {
+	var cmd *exec.Cmd
+	if version == "" {
+		cmd = exec.Command("go", "list", "-m", "-json", mod)
+	} else {
+		cmd = exec.Command("go", "mod", "download", "-json", mod+"@"+version)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+
+	// 'go mod download -json' reports an error in the JSON output.
+	var m struct {
+		Version string
+		Dir     string
+		Error   any
+	}
+	if jsonErr := json.Unmarshal(out, &m); jsonErr != nil || m.Error != nil || err != nil {
+		if version == "" {
+			return module.Version{}, "", fmt.Errorf("uwagaki: %s is not in the build list of the current module; specify a version like %s@latest: %v\n%s", mod, mod, err, stderr.String())
+		}
+		if m.Error != nil {
+			return module.Version{}, "", fmt.Errorf("uwagaki: '%s' failed: %v", cmd, m.Error)
+		}
+		return module.Version{}, "", fmt.Errorf("uwagaki: '%s' failed: %w\n%s", cmd, err, stderr.String())
+	}
+
+	// A module in the build list might not be downloaded yet.
+	if m.Dir == "" {
+		return resolveEditModule(mod, m.Version)
+	}
+	return module.Version{Path: mod, Version: m.Version}, m.Dir, nil
+}
+
+// editFile opens the content in the editor and returns the edited content.
+// The editor is specified by the environment variable EDITOR, and the default is vi.
+func editFile(name string, content []byte, stderr io.Writer) ([]byte, error) {
+	editor := strings.Fields(os.Getenv("EDITOR"))
+	if len(editor) == 0 {
+		editor = []string{"vi"}
+	}
+
+	// Keep the file name for the editor to detect the file type.
+	// The prefix must not start with 'uwagaki-', which is for environments (see uwagaki.GC).
+	dir, err := os.MkdirTemp("", "uwagakiedit-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, name)
+	if err := os.WriteFile(file, content, 0644); err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(editor[0], append(editor[1:], file)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("uwagaki: the editor failed: %w", err)
+	}
+	return os.ReadFile(file)
+}
+
+// patchDirTarget is an editTarget for a patch directory loaded by uwagaki.LoadPatchDir.
+type patchDirTarget struct {
+	dir     string
+	mod     string
+	version string
+	path    string
+}
+
+func (p *patchDirTarget) current(orig []byte) ([]byte, bool, error) {
+	item, _, err := uwagaki.LoadPatchDirFile(p.dir, p.mod, p.version, p.path)
+	if err != nil {
+		return nil, false, err
+	}
+	if item == nil {
+		return nil, false, nil
+	}
+	switch {
+	case item.Transform != nil:
+		content, err := item.Transform(p.path, orig)
+		if err != nil {
+			return nil, false, err
+		}
+		return content, true, nil
+	case item.Delete:
+		return nil, true, nil
+	}
+	return item.Content, true, nil
+}
+
+func (p *patchDirTarget) save(orig, content []byte) error {
+	item, file, err := uwagaki.LoadPatchDirFile(p.dir, p.mod, p.version, p.path)
+	if err != nil {
+		return err
+	}
+
+	if bytes.Equal(orig, content) {
+		if item == nil {
+			return nil
+		}
+		return os.Remove(file)
+	}
+
+	// Keep the format of an existing patch.
+	if item != nil && item.Transform != nil {
+		return os.WriteFile(file, diff.Unified("a/"+p.path, "b/"+p.path, orig, content), 0644)
+	}
+
+	return uwagaki.WritePatchDir(p.dir, []uwagaki.ReplaceItem{
+		{
+			Mod:     p.mod,
+			Path:    p.path,
+			Content: content,
+			Version: p.version,
+		},
+	})
+}
+
+// manifestTarget is an editTarget for a manifest file.
+type manifestTarget struct {
+	path     string
+	mod      string
+	version  string
+	filePath string
+}
+
+func (m *manifestTarget) load() (*uwagaki.Manifest, int, error) {
+	manifest, err := uwagaki.LoadManifest(m.path)
+	if err != nil {
+		return nil, 0, err
+	}
+	for i, r := range manifest.Replaces {
+		if r.Mod == m.mod && r.Pkg == "" && r.Path == m.filePath && r.Version == m.version {
+			return manifest, i, nil
+		}
+	}
+	return manifest, -1, nil
+}
+
+// file returns the path of a file referred by the manifest.
+func (m *manifestTarget) file(name string) string {
+	name = filepath.FromSlash(name)
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(filepath.Dir(m.path), name)
+}
+
+func (m *manifestTarget) current(orig []byte) ([]byte, bool, error) {
+	manifest, i, err := m.load()
+	if err != nil {
+		return nil, false, err
+	}
+	if i < 0 {
+		return nil, false, nil
+	}
+	r := manifest.Replaces[i]
+	switch {
+	case r.Content != nil:
+		return []byte(*r.Content), true, nil
+	case r.File != "":
+		content, err := os.ReadFile(m.file(r.File))
+		if err != nil {
+			return nil, false, err
+		}
+		return content, true, nil
+	case r.Diff != "":
+		patch, err := os.ReadFile(m.file(r.Diff))
+		if err != nil {
+			return nil, false, err
+		}
+		content, err := diff.Apply(orig, patch)
+		if err != nil {
+			return nil, false, fmt.Errorf("uwagaki: applying %s failed: %w", r.Diff, err)
+		}
+		return content, true, nil
+	}
+	// The file is deleted.
+	return nil, true, nil
+}
+
+func (m *manifestTarget) save(orig, content []byte) error {
+	manifest, i, err := m.load()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case bytes.Equal(orig, content):
+		if i < 0 {
+			return nil
+		}
+		manifest.Replaces = append(manifest.Replaces[:i], manifest.Replaces[i+1:]...)
+	case i >= 0 && manifest.Replaces[i].File != "":
+		// Keep the format of an existing replacement.
+		return os.WriteFile(m.file(manifest.Replaces[i].File), content, 0644)
+	case i >= 0 && manifest.Replaces[i].Diff != "":
+		return os.WriteFile(m.file(manifest.Replaces[i].Diff), diff.Unified("a/"+m.filePath, "b/"+m.filePath, orig, content), 0644)
+	case i >= 0:
+		c := string(content)
+		manifest.Replaces[i].Content = &c
+		manifest.Replaces[i].Delete = false
+	default:
+		c := string(content)
+		manifest.Replaces = append(manifest.Replaces, uwagaki.ManifestReplace{
+			Mod:     m.mod,
+			Path:    m.filePath,
+			Content: &c,
+			Version: m.version,
+		})
+	}
+
+	out, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(m.path, append(out, '\n'), 0644)
+}
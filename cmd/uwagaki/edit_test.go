@@ -0,0 +1,168 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2025 Hajime Hoshi
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/hajimehoshi/uwagaki"
+)
+
+// setEditor sets EDITOR to a script appending a line to the file.
+func setEditor(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the editor script requires a shell")
+	}
+	editor := filepath.Join(t.TempDir(), "editor.sh")
+	if err := os.WriteFile(editor, []byte("#!/bin/sh\nprintf '// edited\\n' >> \"$1\"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("EDITOR", editor)
+}
+
+func TestEditPatchDir(t *testing.T) {
+	setEditor(t)
+	dir := createSyncModule(t, "example.com/cmd")
+	t.Chdir(dir)
+
+	patchDir := filepath.Join(dir, "patches")
+	file := filepath.Join(patchDir, "golang.org", "x", "sync@", "errgroup", "errgroup.go")
+
+	for i := range 2 {
+		var stdout, stderr bytes.Buffer
+		if code := run([]string{"edit", "-patchdir", patchDir, "golang.org/x/sync", "errgroup/errgroup.go"}, &stdout, &stderr); code != 0 {
+			t.Fatalf("exit code: got: %d, want: 0\n%s", code, stderr.String())
+		}
+		// The second edit starts from the replaced content.
+		if got, want := strings.Count(string(mustReadFile(file)), "// edited\n"), i+1; got != want {
+			t.Errorf("edited lines: got: %d, want: %d", got, want)
+		}
+		if got, want := stdout.String(), "--- a/golang.org/x/sync@v0.11.0/errgroup/errgroup.go\n+++ b/golang.org/x/sync@v0.11.0/errgroup/errgroup.go\n"; !strings.HasPrefix(got, want) {
+			t.Errorf("diff: got: %q, want prefix: %q", got, want)
+		}
+	}
+
+	items, err := uwagaki.LoadPatchDir(patchDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(items), 1; got != want {
+		t.Fatalf("len(items): got: %d, want: %d", got, want)
+	}
+	if got, want := items[0].Version, ""; got != want {
+		t.Errorf("version: got: %q, want: %q", got, want)
+	}
+}
+
+func TestEditPatchMarker(t *testing.T) {
+	setEditor(t)
+	dir := createSyncModule(t, "example.com/cmd")
+	t.Chdir(dir)
+
+	patchDir := filepath.Join(dir, "patches")
+	file := filepath.Join(patchDir, "golang.org", "x", "sync@v0.11.0", "doc.go")
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file+".uwagaki-patch", []byte(`@@ -0,0 +1 @@
++// patched
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"edit", "-patchdir=" + patchDir, "golang.org/x/sync@v0.11.0", "doc.go"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("exit code: got: %d, want: 0\n%s", code, stderr.String())
+	}
+	// The patch is kept as a patch.
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("the replaced file must not exist: %v", err)
+	}
+	patch := string(mustReadFile(file + ".uwagaki-patch"))
+	for _, line := range []string{"+// patched\n", "+// edited\n"} {
+		if !strings.Contains(patch, line) {
+			t.Errorf("patch: got: %q, want: includes %q", patch, line)
+		}
+	}
+}
+
+func TestEditManifest(t *testing.T) {
+	setEditor(t)
+	dir := createSyncModule(t, "example.com/cmd")
+	t.Chdir(dir)
+
+	manifest := filepath.Join(dir, "uwagaki.json")
+	if err := os.WriteFile(manifest, []byte(`{"packages": ["."]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"edit", "-manifest", manifest, "golang.org/x/sync@v0.10.0", "errgroup/errgroup.go"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("exit code: got: %d, want: 0\n%s", code, stderr.String())
+	}
+	if got, want := stdout.String(), "--- a/golang.org/x/sync@v0.10.0/errgroup/errgroup.go\n"; !strings.HasPrefix(got, want) {
+		t.Errorf("diff: got: %q, want prefix: %q", got, want)
+	}
+
+	m, err := uwagaki.LoadManifest(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(m.Packages), 1; got != want {
+		t.Errorf("len(m.Packages): got: %d, want: %d", got, want)
+	}
+	if got, want := len(m.Replaces), 1; got != want {
+		t.Fatalf("len(m.Replaces): got: %d, want: %d", got, want)
+	}
+	r := m.Replaces[0]
+	if got, want := r.Version, "v0.10.0"; got != want {
+		t.Errorf("version: got: %q, want: %q", got, want)
+	}
+	if r.Content == nil || !strings.HasSuffix(*r.Content, "// edited\n") {
+		t.Errorf("content doesn't include the edited line")
+	}
+}
+
+func TestEditError(t *testing.T) {
+	setEditor(t)
+	dir := createSyncModule(t, "example.com/cmd")
+	t.Chdir(dir)
+
+	for _, tc := range []struct {
+		args []string
+		code int
+	}{
+		{
+			args: []string{"edit", "golang.org/x/sync", "errgroup/errgroup.go"},
+			code: 2,
+		},
+		{
+			args: []string{"edit", "-patchdir=p", "-manifest=m", "golang.org/x/sync", "errgroup/errgroup.go"},
+			code: 2,
+		},
+		{
+			args: []string{"edit", "-patchdir=p", "golang.org/x/sync"},
+			code: 2,
+		},
+		{
+			args: []string{"edit", "-patchdir=p", "golang.org/x/sync", "../errgroup.go"},
+			code: 1,
+		},
+		{
+			// The module is not in the build list.
+			args: []string{"edit", "-patchdir=p", "golang.org/x/text", "language/language.go"},
+			code: 1,
+		},
+	} {
+		var stdout, stderr bytes.Buffer
+		if got, want := run(tc.args, &stdout, &stderr), tc.code; got != want {
+			t.Errorf("run(%q): exit code: got: %d, want: %d\n%s", tc.args, got, want, stderr.String())
+		}
+	}
+}
@@ -0,0 +1,802 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2025 Hajime Hoshi
+
+// Uwagaki runs go commands with files in external modules replaced.
+//
+// Usage:
+//
+//	uwagaki <command> [arguments]
+//
+// The commands are:
+//
+//	run    compile and run a Go program in an environment
+//	build  compile packages in an environment
+//	test   test packages in an environment
+//	exec   run a command in an environment
+//	tool   run a tool in an environment like 'go tool'
+//	edit   edit a file in a module and save it as a replacement
+//	env    create an environment and print its directory
+//	diff   print the replaced files as a unified diff
+//	clean  remove environments
+//	gc     remove stale environments
+//
+// The synopses of the commands are:
+//
+//	uwagaki run [flags] [build flags] package [--] [arguments...]
+//	uwagaki build [flags] [build flags] [packages]
+//	uwagaki test [flags] [build/test flags] [packages] [-- test binary flags]
+//	uwagaki exec [flags] [packages] -- command [arguments...]
+//	uwagaki tool [flags] name [arguments...]
+//	uwagaki edit -patchdir dir|-manifest file module[@version] path
+//	uwagaki env [-json] [flags] [packages]
+//	uwagaki diff [flags] [packages]
+//	uwagaki diff -env dir
+//	uwagaki clean dir...
+//	uwagaki gc [-age duration] [-n] [-dir dir]
+//
+// The flags to describe an environment are:
+//
+//	-replace mod:path=file
+//		Replace the file at path in the module mod with the content of file.
+//		The flag can be repeated.
+//	-patchdir dir
+//		Load replacements from the directory. See uwagaki.LoadPatchDir for the directory structure.
+//		The flag can be repeated.
+//	-manifest file
+//		Load packages, replacements, and options from the manifest file. See uwagaki.Manifest.
+//	-layout module|workspace|modfile
+//		The layout of the environment. The default is the manifest's layout or module.
+//	-tool pkg[@version]
+//		Add the tool to the environment. See uwagaki.Options.Tools.
+//		The flag can be repeated.
+//
+// The replacements are merged in the order of the manifest, the patch directories, and the -replace flags,
+// so a later one overrides an earlier one for the same file.
+// If no packages are given, the manifest's packages or the current directory is used.
+//
+// The other flags of run, build, and test are passed to the go command as they are,
+// except that relative output paths like -o and -coverprofile are resolved in the current directory.
+//
+// exec runs the command in the environment, e.g. 'go generate', a debugger, a linter, or a shell script.
+// The command runs in the directory where go commands work with the environment variables like GOWORK or GOFLAGS for the environment.
+// A command path with a separator like './scripts/check.sh' is resolved in the current directory.
+// An argument of the command equal to one of the packages is replaced with the package path in the environment,
+// e.g. 'uwagaki exec ./cmd/foo -- dlv debug ./cmd/foo'.
+//
+// tool builds the tool in the environment and runs it in the current directory, like 'go tool'.
+// The tool directives in the current go.mod are available.
+// This is useful to use a code generator with replaced files from a go:generate directive, e.g.
+// '//go:generate uwagaki tool -patchdir ../patches stringer -type=Pill'.
+//
+// edit opens the file at path in the module with the editor specified by $EDITOR (the default is vi),
+// and saves the result as a replacement to the patch directory or the manifest, printing a diff against the original file.
+// If the file is already replaced, the replaced content is edited, and the format of the replacement like a patch file is kept.
+// Without a version, the module version in the build list of the current module is used, and the replacement is applied to any versions.
+// With a version like 'golang.org/x/sync@v0.11.0' or 'golang.org/x/sync@latest', the replacement is applied only to the version.
+// The manifest is rewritten with the replacement.
+//
+// env creates an environment and prints its directory without removing it.
+// With -json, env prints the directory, the working directory, the environment variables, and the paths as JSON.
+// Remove the environment by 'uwagaki clean' after using it.
+//
+// diff prints the replaced files as a unified diff against the original modules.
+// With -env, diff prints the diff of the existing environment instead of creating a new one.
+//
+// clean removes the given environments. A directory that is not an environment is not removed.
+//
+// gc removes stale environments left by crashed processes or forgotten cleanups, and prints the removed directories.
+// An environment is stale if its creator process is no longer running, or it is older than -age (the default is 24h).
+// An environment created by env is removed only by its age.
+// A directory without the metadata of an environment is never removed.
+// -age=0 disables removing environments by their ages.
+// With -n, gc prints the directories without removing them.
+// -dir specifies the directory including environments. The default is the temporary directory.
+//
+// The exit code of run, build, and test is the exit code of the go command, and the exit code of exec and tool is the exit code of the command.
+// The exit code is 2 for a usage error, and 1 for the other errors.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/hajimehoshi/uwagaki"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+const usage = `Uwagaki runs go commands with files in external modules replaced.
+
+Usage:
+
+	uwagaki <command> [arguments]
+
+The commands are:
+
+	run    compile and run a Go program in an environment
+	build  compile packages in an environment
+	test   test packages in an environment
+	exec   run a command in an environment
+	tool   run a tool in an environment like 'go tool'
+	edit   edit a file in a module and save it as a replacement
+	env    create an environment and print its directory
+	diff   print the replaced files as a unified diff
+	clean  remove environments
+	gc     remove stale environments
+`
+
+var commandUsages = map[string]string{
+	"run":   "uwagaki run [-replace mod:path=file] [-patchdir dir] [-manifest file] [-layout layout] [-tool pkg] [build flags] package [--] [arguments...]",
+	"build": "uwagaki build [-replace mod:path=file] [-patchdir dir] [-manifest file] [-layout layout] [-tool pkg] [build flags] [packages]",
+	"test":  "uwagaki test [-replace mod:path=file] [-patchdir dir] [-manifest file] [-layout layout] [-tool pkg] [build/test flags] [packages] [-- test binary flags]",
+	"exec":  "uwagaki exec [-replace mod:path=file] [-patchdir dir] [-manifest file] [-layout layout] [-tool pkg] [packages] -- command [arguments...]",
+	"tool":  "uwagaki tool [-replace mod:path=file] [-patchdir dir] [-manifest file] [-layout layout] [-tool pkg] name [arguments...]",
+	"edit":  "uwagaki edit -patchdir dir|-manifest file module[@version] path",
+	"env":   "uwagaki env [-json] [-replace mod:path=file] [-patchdir dir] [-manifest file] [-layout layout] [-tool pkg] [packages]",
+	"diff":  "uwagaki diff [-replace mod:path=file] [-patchdir dir] [-manifest file] [-layout layout] [-tool pkg] [packages]\n       uwagaki diff -env dir",
+	"clean": "uwagaki clean dir...",
+	"gc":    "uwagaki gc [-age duration] [-n] [-dir dir]",
+}
+
+// usageError is an error for invalid arguments. The exit code is 2.
+type usageError struct {
+	command string
+	err     error
+}
+
+func (u *usageError) Error() string {
+	return fmt.Sprintf("uwagaki %s: %v", u.command, u.err)
+}
+
+// exitError is an error to exit with the code without any messages.
+type exitError struct {
+	code int
+}
+
+func (e *exitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.code)
+}
+
+// run runs the command with the arguments and returns the exit code.
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "-help" || args[0] == "--help" {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+
+	command := args[0]
+	var err error
+	switch command {
+	case "run", "build", "test":
+		err = runGo(command, args[1:], stdout, stderr)
+	case "exec":
+		err = runExec(args[1:], stdout, stderr)
+	case "tool":
+		err = runTool(args[1:], stdout, stderr)
+	case "edit":
+		err = runEdit(args[1:], stdout, stderr)
+	case "env":
+		err = runEnv(args[1:], stdout)
+	case "diff":
+		err = runDiff(args[1:], stdout)
+	case "clean":
+		err = runClean(args[1:])
+	case "gc":
+		err = runGC(args[1:], stdout)
+	default:
+		fmt.Fprintf(stderr, "uwagaki %s: unknown command\nRun 'uwagaki help' for usage.\n", command)
+		return 2
+	}
+
+	if err == nil {
+		return 0
+	}
+	var e *exitError
+	if errors.As(err, &e) {
+		return e.code
+	}
+	var u *usageError
+	if errors.As(err, &u) {
+		fmt.Fprintln(stderr, u)
+		fmt.Fprintf(stderr, "usage: %s\n", commandUsages[u.command])
+		return 2
+	}
+	fmt.Fprintln(stderr, err)
+	return 1
+}
+
+// envFlags represents flags to describe an environment.
+type envFlags struct {
+	replaces  []uwagaki.ReplaceItem
+	patchDirs []string
+	manifest  string
+	layout    string
+	tools     []string
+
+	// detached indicates that the environment is used after uwagaki exits. See uwagaki.Options.Detached.
+	detached bool
+}
+
+var envFlagNames = map[string]struct{}{
+	"replace":  {},
+	"patchdir": {},
+	"manifest": {},
+	"layout":   {},
+	"tool":     {},
+}
+
+var layouts = map[string]uwagaki.Layout{
+	"module":    uwagaki.LayoutModule,
+	"workspace": uwagaki.LayoutWorkspace,
+	"modfile":   uwagaki.LayoutModFile,
+}
+
+// set sets the environment flag of the name.
+func (f *envFlags) set(name, value string) error {
+	switch name {
+	case "replace":
+		target, file, ok := strings.Cut(value, "=")
+		if !ok {
+			return fmt.Errorf("invalid -replace %q: must be mod:path=file", value)
+		}
+		mod, path, ok := strings.Cut(target, ":")
+		if !ok || mod == "" || path == "" || file == "" {
+			return fmt.Errorf("invalid -replace %q: must be mod:path=file", value)
+		}
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		f.replaces = append(f.replaces, uwagaki.ReplaceItem{
+			Mod:     mod,
+			Path:    path,
+			Content: content,
+		})
+	case "patchdir":
+		f.patchDirs = append(f.patchDirs, value)
+	case "manifest":
+		if f.manifest != "" {
+			return fmt.Errorf("-manifest is specified more than once")
+		}
+		f.manifest = value
+	case "layout":
+		if _, ok := layouts[value]; !ok {
+			return fmt.Errorf("invalid -layout %q: must be module, workspace, or modfile", value)
+		}
+		f.layout = value
+	case "tool":
+		f.tools = append(f.tools, value)
+	default:
+		return fmt.Errorf("unknown flag: -%s", name)
+	}
+	return nil
+}
+
+func (f *envFlags) isZero() bool {
+	return len(f.replaces) == 0 && len(f.patchDirs) == 0 && f.manifest == "" && f.layout == "" && len(f.tools) == 0
+}
+
+// newEnvironment creates a new environment for the packages.
+func (f *envFlags) newEnvironment(pkgs []string) (*uwagaki.Environment, error) {
+	var layers [][]uwagaki.ReplaceItem
+	options := &uwagaki.Options{}
+	if f.manifest != "" {
+		m, err := uwagaki.LoadManifest(f.manifest)
+		if err != nil {
+			return nil, err
+		}
+		items, err := m.ReplaceItems()
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, items)
+		options = m.EnvironmentOptions()
+		if len(pkgs) == 0 {
+			pkgs = m.Packages
+		}
+	}
+	for _, dir := range f.patchDirs {
+		items, err := uwagaki.LoadPatchDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, items)
+	}
+	layers = append(layers, f.replaces)
+
+	items, err := uwagaki.MergeLayers(layers...)
+	if err != nil {
+		return nil, err
+	}
+	if f.layout != "" {
+		options.Layout = layouts[f.layout]
+	}
+	options.Tools = append(options.Tools, f.tools...)
+	options.Detached = f.detached
+	return uwagaki.NewEnvironment(pkgs, items, options)
+}
+
+// flagName returns the name and the value of a flag like '-name=value' or '--name'.
+// flagName returns false if the argument is not a flag.
+func flagName(arg string) (name, value string, hasValue bool, ok bool) {
+	if len(arg) < 2 || arg[0] != '-' || arg == "--" {
+		return "", "", false, false
+	}
+	name = strings.TrimPrefix(arg[1:], "-")
+	name, value, hasValue = strings.Cut(name, "=")
+	return name, value, hasValue, true
+}
+
+// goValueFlags is a set of go command flags that take a value.
+var goValueFlags = map[string]struct{}{
+	"C": {}, "asmflags": {}, "buildmode": {}, "compiler": {}, "covermode": {}, "coverpkg": {}, "exec": {},
+	"gccgoflags": {}, "gcflags": {}, "installsuffix": {}, "ldflags": {}, "mod": {}, "modfile": {}, "o": {},
+	"overlay": {}, "p": {}, "pgo": {}, "pkgdir": {}, "tags": {}, "toolexec": {},
+
+	// Test flags
+	"bench": {}, "benchtime": {}, "blockprofile": {}, "blockprofilerate": {}, "count": {}, "coverprofile": {},
+	"cpu": {}, "cpuprofile": {}, "fuzz": {}, "fuzzminimizetime": {}, "fuzztime": {}, "list": {},
+	"memprofile": {}, "memprofilerate": {}, "mutexprofile": {}, "mutexprofilefraction": {}, "outputdir": {},
+	"parallel": {}, "run": {}, "shuffle": {}, "skip": {}, "timeout": {}, "trace": {}, "vet": {},
+}
+
+// goPathFlags is a set of go command flags whose values are file paths.
+// The go command runs in the environment directory, so relative paths must be resolved in the current directory.
+var goPathFlags = map[string]struct{}{
+	"blockprofile": {}, "coverprofile": {}, "cpuprofile": {}, "memprofile": {}, "modfile": {}, "mutexprofile": {},
+	"o": {}, "outputdir": {}, "overlay": {}, "pgo": {}, "pkgdir": {}, "trace": {},
+}
+
+// goArgs represents parsed arguments for run, build, and test.
+type goArgs struct {
+	envFlags
+	goFlags []string
+	pkgs    []string
+	args    []string
+}
+
+func parseGoArgs(command string, args []string) (*goArgs, error) {
+	var a goArgs
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" || (command == "test" && arg == "-args") {
+			if command == "build" {
+				return nil, fmt.Errorf("arguments are not available")
+			}
+			a.args = args[i+1:]
+			break
+		}
+
+		name, value, hasValue, ok := flagName(arg)
+		if !ok {
+			a.pkgs = append(a.pkgs, arg)
+			if command == "run" {
+				// The rest are arguments of the program, unless they are Go files.
+				if strings.HasSuffix(arg, ".go") {
+					continue
+				}
+				a.args = args[i+1:]
+				if len(a.args) > 0 && a.args[0] == "--" {
+					a.args = a.args[1:]
+				}
+				break
+			}
+			continue
+		}
+
+		_, isEnvFlag := envFlagNames[name]
+		_, isValueFlag := goValueFlags[name]
+		if !hasValue && (isEnvFlag || isValueFlag) {
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("flag needs an argument: %s", arg)
+			}
+			i++
+			value = args[i]
+		}
+		if isEnvFlag {
+			if err := a.set(name, value); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if name == "C" {
+			return nil, fmt.Errorf("-C is not available")
+		}
+		if !hasValue && !isValueFlag {
+			a.goFlags = append(a.goFlags, arg)
+			continue
+		}
+		if _, ok := goPathFlags[name]; ok && value != "" && (name != "pgo" || (value != "auto" && value != "off")) {
+			abs, err := filepath.Abs(value)
+			if err != nil {
+				return nil, err
+			}
+			// Keep a trailing separator, as it means a directory for -o.
+			if strings.HasSuffix(value, "/") || strings.HasSuffix(value, string(filepath.Separator)) {
+				abs += string(filepath.Separator)
+			}
+			value = abs
+		}
+		a.goFlags = append(a.goFlags, "-"+name+"="+value)
+	}
+	if command == "run" && len(a.pkgs) == 0 {
+		return nil, fmt.Errorf("no go files listed")
+	}
+	return &a, nil
+}
+
+// hasGoFlag reports whether the go flags include the flag.
+func (a *goArgs) hasGoFlag(name string) bool {
+	return slices.ContainsFunc(a.goFlags, func(arg string) bool {
+		n, _, _, _ := flagName(arg)
+		return n == name
+	})
+}
+
+func runGo(command string, args []string, stdout, stderr io.Writer) error {
+	a, err := parseGoArgs(command, args)
+	if err != nil {
+		return &usageError{command: command, err: err}
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	env, err := a.newEnvironment(a.pkgs)
+	if err != nil {
+		return err
+	}
+	defer env.Close()
+
+	cmdArgs := []string{command}
+	cmdArgs = append(cmdArgs, a.goFlags...)
+	// The go command writes an executable to the current directory, which is the environment directory.
+	// Write it to the original current directory instead.
+	if !a.hasGoFlag("o") && writesExecutable(env, command, a) {
+		cmdArgs = append(cmdArgs, "-o="+wd+string(filepath.Separator))
+	}
+	cmdArgs = append(cmdArgs, env.Paths()...)
+	if len(a.args) > 0 {
+		if command == "test" {
+			cmdArgs = append(cmdArgs, "-args")
+		}
+		cmdArgs = append(cmdArgs, a.args...)
+	}
+
+	return runCommand(env.Command("go", cmdArgs...), stdout, stderr)
+}
+
+func runExec(args []string, stdout, stderr io.Writer) error {
+	i := slices.Index(args, "--")
+	if i < 0 || i == len(args)-1 {
+		return &usageError{command: "exec", err: fmt.Errorf("no command specified")}
+	}
+	f, pkgs, err := parseEnvArgs(args[:i], nil)
+	if err != nil {
+		return &usageError{command: "exec", err: err}
+	}
+
+	env, err := f.newEnvironment(pkgs)
+	if err != nil {
+		return err
+	}
+	defer env.Close()
+
+	// The command runs in the environment, so a relative command path is resolved against the current directory.
+	name := args[i+1]
+	if strings.ContainsRune(name, '/') || strings.ContainsRune(name, filepath.Separator) {
+		abs, err := filepath.Abs(name)
+		if err != nil {
+			return err
+		}
+		name = abs
+	}
+	return runCommand(env.Command(name, env.TranslateArgs(args[i+2:])...), stdout, stderr)
+}
+
+func runTool(args []string, stdout, stderr io.Writer) error {
+	// Find the tool name. All the environment flags take values.
+	var i int
+	for i = 0; i < len(args); i++ {
+		name, _, hasValue, ok := flagName(args[i])
+		if !ok {
+			break
+		}
+		if _, ok := envFlagNames[name]; ok && !hasValue {
+			i++
+		}
+	}
+	flags := args[:min(i, len(args))]
+	if i < len(args) && args[i] == "--" {
+		i++
+	}
+	if i >= len(args) {
+		return &usageError{command: "tool", err: fmt.Errorf("no tool specified")}
+	}
+	f, _, err := parseEnvArgs(flags, nil)
+	if err != nil {
+		return &usageError{command: "tool", err: err}
+	}
+
+	env, err := f.newEnvironment(nil)
+	if err != nil {
+		return err
+	}
+	defer env.Close()
+
+	cmd, err := env.ToolCommand(args[i], args[i+1:]...)
+	if err != nil {
+		return err
+	}
+	return runCommand(cmd, stdout, stderr)
+}
+
+// runCommand runs the command with the standard input and returns an *exitError if the command fails.
+func runCommand(cmd *exec.Cmd, stdout, stderr io.Writer) error {
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	// Ignore interrupts while the command is running, as the command receives and handles them.
+	// This ensures the environment is removed.
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	defer signal.Stop(sig)
+
+	if err := cmd.Run(); err != nil {
+		var e *exec.ExitError
+		if errors.As(err, &e) && e.ExitCode() >= 0 {
+			return &exitError{code: e.ExitCode()}
+		}
+		return err
+	}
+	return nil
+}
+
+// writesExecutable reports whether the go command writes an executable to the current directory without -o.
+func writesExecutable(env *uwagaki.Environment, command string, a *goArgs) bool {
+	switch command {
+	case "build":
+		// 'go build' writes an executable only for a single main package.
+		// A list of Go files is a single package.
+		paths := env.Paths()
+		goFiles := len(paths) > 0 && !slices.ContainsFunc(paths, func(path string) bool {
+			return !strings.HasSuffix(path, ".go")
+		})
+		if !goFiles && (len(paths) != 1 || strings.Contains(paths[0], "...")) {
+			return false
+		}
+		out, err := env.Command("go", append([]string{"list", "-f", "{{.Name}}"}, paths...)...).Output()
+		return err == nil && strings.TrimSpace(string(out)) == "main"
+	case "test":
+		return a.hasGoFlag("c")
+	}
+	return false
+}
+
+// parseEnvArgs parses the arguments of env and diff.
+// extra is called for a flag that is not an environment flag, and reports whether the flag is consumed.
+func parseEnvArgs(args []string, extra func(name, value string, hasValue bool, next func() (string, error)) (bool, error)) (*envFlags, []string, error) {
+	var f envFlags
+	var pkgs []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			pkgs = append(pkgs, args[i+1:]...)
+			break
+		}
+		name, value, hasValue, ok := flagName(arg)
+		if !ok {
+			pkgs = append(pkgs, arg)
+			continue
+		}
+		next := func() (string, error) {
+			if hasValue {
+				return value, nil
+			}
+			if i+1 >= len(args) {
+				return "", fmt.Errorf("flag needs an argument: %s", arg)
+			}
+			i++
+			return args[i], nil
+		}
+		if extra != nil {
+			ok, err := extra(name, value, hasValue, next)
+			if err != nil {
+				return nil, nil, err
+			}
+			if ok {
+				continue
+			}
+		}
+		if _, ok := envFlagNames[name]; !ok {
+			return nil, nil, fmt.Errorf("flag provided but not defined: %s", arg)
+		}
+		v, err := next()
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := f.set(name, v); err != nil {
+			return nil, nil, err
+		}
+	}
+	return &f, pkgs, nil
+}
+
+// boolFlag returns the value of a boolean flag.
+func boolFlag(arg, value string, hasValue bool) (bool, error) {
+	if !hasValue {
+		return true, nil
+	}
+	switch value {
+	case "true", "1":
+		return true, nil
+	case "false", "0":
+		return false, nil
+	}
+	return false, fmt.Errorf("invalid boolean value %q for %s", value, arg)
+}
+
+// environmentJSON is the output of 'uwagaki env -json'.
+type environmentJSON struct {
+	Dir        string
+	WorkingDir string
+	Env        []string
+	Paths      []string
+}
+
+func runEnv(args []string, stdout io.Writer) error {
+	var jsonOutput bool
+	f, pkgs, err := parseEnvArgs(args, func(name, value string, hasValue bool, next func() (string, error)) (bool, error) {
+		if name != "json" {
+			return false, nil
+		}
+		v, err := boolFlag("-json", value, hasValue)
+		if err != nil {
+			return true, err
+		}
+		jsonOutput = v
+		return true, nil
+	})
+	if err != nil {
+		return &usageError{command: "env", err: err}
+	}
+
+	// The environment is kept after uwagaki exits, so GC must not remove it because uwagaki is not running.
+	f.detached = true
+	env, err := f.newEnvironment(pkgs)
+	if err != nil {
+		return err
+	}
+
+	if !jsonOutput {
+		fmt.Fprintln(stdout, env.Dir())
+		return nil
+	}
+	out, err := json.MarshalIndent(environmentJSON{
+		Dir:        env.Dir(),
+		WorkingDir: env.WorkingDir(),
+		Env:        env.Env(),
+		Paths:      env.Paths(),
+	}, "", "\t")
+	if err != nil {
+		_ = env.Close()
+		return err
+	}
+	fmt.Fprintf(stdout, "%s\n", out)
+	return nil
+}
+
+func runDiff(args []string, stdout io.Writer) error {
+	var envDir string
+	f, pkgs, err := parseEnvArgs(args, func(name, value string, hasValue bool, next func() (string, error)) (bool, error) {
+		if name != "env" {
+			return false, nil
+		}
+		v, err := next()
+		if err != nil {
+			return true, err
+		}
+		envDir = v
+		return true, nil
+	})
+	if err != nil {
+		return &usageError{command: "diff", err: err}
+	}
+
+	if envDir != "" {
+		if !f.isZero() || len(pkgs) > 0 {
+			return &usageError{command: "diff", err: fmt.Errorf("-env cannot be used with other flags or packages")}
+		}
+		env, err := uwagaki.OpenEnvironment(envDir)
+		if err != nil {
+			return err
+		}
+		return env.Diff(stdout)
+	}
+
+	env, err := f.newEnvironment(pkgs)
+	if err != nil {
+		return err
+	}
+	defer env.Close()
+	return env.Diff(stdout)
+}
+
+func runClean(args []string) error {
+	if len(args) == 0 {
+		return &usageError{command: "clean", err: fmt.Errorf("no environments listed")}
+	}
+	for _, arg := range args {
+		if _, _, _, ok := flagName(arg); ok {
+			return &usageError{command: "clean", err: fmt.Errorf("flag provided but not defined: %s", arg)}
+		}
+	}
+
+	var errs []error
+	for _, dir := range args {
+		if err := uwagaki.RemoveEnvironment(dir); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func runGC(args []string, stdout io.Writer) error {
+	options := &uwagaki.GCOptions{
+		MaxAge: 24 * time.Hour,
+	}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		name, value, hasValue, ok := flagName(arg)
+		if !ok {
+			return &usageError{command: "gc", err: fmt.Errorf("unexpected argument: %s", arg)}
+		}
+		if name == "n" {
+			v, err := boolFlag(arg, value, hasValue)
+			if err != nil {
+				return &usageError{command: "gc", err: err}
+			}
+			options.DryRun = v
+			continue
+		}
+		if name != "age" && name != "dir" {
+			return &usageError{command: "gc", err: fmt.Errorf("flag provided but not defined: %s", arg)}
+		}
+		if !hasValue {
+			if i+1 >= len(args) {
+				return &usageError{command: "gc", err: fmt.Errorf("flag needs an argument: %s", arg)}
+			}
+			i++
+			value = args[i]
+		}
+		if name == "dir" {
+			options.Dir = value
+			continue
+		}
+		age, err := time.ParseDuration(value)
+		if err != nil || age < 0 {
+			return &usageError{command: "gc", err: fmt.Errorf("invalid -age %q", value)}
+		}
+		options.MaxAge = age
+	}
+
+	removed, err := uwagaki.GC(options)
+	for _, dir := range removed {
+		fmt.Fprintln(stdout, dir)
+	}
+	return err
+}
@@ -0,0 +1,490 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2025 Hajime Hoshi
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// createSyncModule creates a temporary module that requires golang.org/x/sync v0.11.0 and calls a function added by uwagaki.
+func createSyncModule(t *testing.T, mod string) string {
+	dir := t.TempDir()
+	{
+		cmd := exec.Command("go", "mod", "init", mod)
+		cmd.Stderr = os.Stderr
+		cmd.Dir = dir
+		if err := cmd.Run(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	{
+		cmd := exec.Command("go", "get", "golang.org/x/sync@v0.11.0")
+		cmd.Stderr = os.Stderr
+		cmd.Dir = dir
+		if err := cmd.Run(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), mustReadFile("../../testdata/stringer/main.go"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func mustReadFile(path string) []byte {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func TestParseGoArgs(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		command   string
+		args      []string
+		goFlags   []string
+		pkgs      []string
+		progArgs  []string
+		patchDirs []string
+		err       bool
+	}{
+		{
+			command: "run",
+			args:    []string{"-patchdir", "patches", "-race", ".", "-foo", "bar"},
+			goFlags: []string{"-race"},
+			pkgs:    []string{"."},
+			progArgs: []string{
+				"-foo", "bar",
+			},
+			patchDirs: []string{"patches"},
+		},
+		{
+			command:  "run",
+			args:     []string{"main.go", "sub.go", "--", "-foo"},
+			pkgs:     []string{"main.go", "sub.go"},
+			progArgs: []string{"-foo"},
+		},
+		{
+			command: "run",
+			args:    []string{"-race"},
+			err:     true,
+		},
+		{
+			command: "build",
+			args:    []string{"-o", "out", "-tags=foo", "./...", "-patchdir=patches"},
+			goFlags: []string{"-o=" + filepath.Join(wd, "out"), "-tags=foo"},
+			pkgs:    []string{"./..."},
+			// A flag after packages is still a flag for build and test.
+			patchDirs: []string{"patches"},
+		},
+		{
+			command: "build",
+			args:    []string{"-o", "out/"},
+			goFlags: []string{"-o=" + filepath.Join(wd, "out") + string(filepath.Separator)},
+		},
+		{
+			command: "build",
+			args:    []string{".", "--", "foo"},
+			err:     true,
+		},
+		{
+			command:  "test",
+			args:     []string{"-run", "TestFoo", "-coverprofile=c.out", "./...", "-v", "--", "-foo"},
+			goFlags:  []string{"-run=TestFoo", "-coverprofile=" + filepath.Join(wd, "c.out"), "-v"},
+			pkgs:     []string{"./..."},
+			progArgs: []string{"-foo"},
+		},
+		{
+			command:  "test",
+			args:     []string{".", "-args", "-foo"},
+			pkgs:     []string{"."},
+			progArgs: []string{"-foo"},
+		},
+		{
+			command: "test",
+			args:    []string{"-pgo=auto"},
+			goFlags: []string{"-pgo=auto"},
+		},
+		{
+			command: "test",
+			args:    []string{"-run"},
+			err:     true,
+		},
+		{
+			command: "test",
+			args:    []string{"-layout", "foo"},
+			err:     true,
+		},
+		{
+			command: "build",
+			args:    []string{"-replace", "golang.org/x/sync"},
+			err:     true,
+		},
+		{
+			command: "build",
+			args:    []string{"-C", "foo"},
+			err:     true,
+		},
+	}
+	for _, tc := range testCases {
+		a, err := parseGoArgs(tc.command, tc.args)
+		if err != nil {
+			if !tc.err {
+				t.Errorf("parseGoArgs(%q, %q) failed: %v", tc.command, tc.args, err)
+			}
+			continue
+		}
+		if tc.err {
+			t.Errorf("parseGoArgs(%q, %q) must fail", tc.command, tc.args)
+			continue
+		}
+		if got, want := a.goFlags, tc.goFlags; !slices.Equal(got, want) {
+			t.Errorf("parseGoArgs(%q, %q) go flags: got: %q, want: %q", tc.command, tc.args, got, want)
+		}
+		if got, want := a.pkgs, tc.pkgs; !slices.Equal(got, want) {
+			t.Errorf("parseGoArgs(%q, %q) packages: got: %q, want: %q", tc.command, tc.args, got, want)
+		}
+		if got, want := a.args, tc.progArgs; !slices.Equal(got, want) {
+			t.Errorf("parseGoArgs(%q, %q) arguments: got: %q, want: %q", tc.command, tc.args, got, want)
+		}
+		if got, want := a.patchDirs, tc.patchDirs; !slices.Equal(got, want) {
+			t.Errorf("parseGoArgs(%q, %q) patch dirs: got: %q, want: %q", tc.command, tc.args, got, want)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	patch, err := filepath.Abs("../../testdata/sync/additional_file_by_uwagaki.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := createSyncModule(t, "example.com/cmd")
+	t.Chdir(dir)
+
+	replace := "-replace=golang.org/x/sync:additional_file_by_uwagaki.go=" + patch
+
+	testCases := []struct {
+		args   []string
+		code   int
+		stdout string
+	}{
+		{
+			args:   []string{"run", replace, "."},
+			code:   0,
+			stdout: "Hello, Uwagaki (sync)!\n",
+		},
+		{
+			// Without the replacement, the compilation fails.
+			args: []string{"run", "."},
+			code: 1,
+		},
+		{
+			args: []string{"build", replace, "-o", "out/", "."},
+			code: 0,
+		},
+		{
+			args: []string{"run", "-layout=modfile", "-unknownflag", "."},
+			code: 2,
+		},
+		{
+			args: []string{"unknown"},
+			code: 2,
+		},
+		{
+			args: []string{"clean"},
+			code: 2,
+		},
+		{
+			args: []string{"clean", dir},
+			code: 1,
+		},
+		{
+			args:   []string{"diff", replace},
+			code:   0,
+			stdout: "--- /dev/null\n+++ b/golang.org/x/sync@v0.11.0/additional_file_by_uwagaki.go\n",
+		},
+	}
+	for _, tc := range testCases {
+		var stdout, stderr bytes.Buffer
+		code := run(tc.args, &stdout, &stderr)
+		if got, want := code, tc.code; got != want {
+			t.Errorf("run(%q): exit code: got: %d, want: %d\n%s", tc.args, got, want, stderr.String())
+		}
+		if tc.stdout != "" && !strings.HasPrefix(stdout.String(), tc.stdout) {
+			t.Errorf("run(%q): stdout: got: %q, want prefix: %q", tc.args, stdout.String(), tc.stdout)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "out", "cmd")); err != nil {
+		t.Errorf("the built executable doesn't exist: %v", err)
+	}
+}
+
+func TestBuildExecutable(t *testing.T) {
+	patch, err := filepath.Abs("../../testdata/sync/additional_file_by_uwagaki.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := createSyncModule(t, "example.com/cmd")
+	t.Chdir(dir)
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"build", "-replace=golang.org/x/sync:additional_file_by_uwagaki.go=" + patch}, &stdout, &stderr); code != 0 {
+		t.Fatalf("exit code: got: %d, want: 0\n%s", code, stderr.String())
+	}
+	// The executable is written to the current directory like 'go build'.
+	if _, err := os.Stat(filepath.Join(dir, "cmd")); err != nil {
+		t.Errorf("the built executable doesn't exist: %v", err)
+	}
+
+	// Go files are a single package, and the executable is named after the first file.
+	if err := os.WriteFile(filepath.Join(dir, "util.go"), []byte("package main\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if code := run([]string{"build", "-replace=golang.org/x/sync:additional_file_by_uwagaki.go=" + patch, "main.go", "util.go"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("exit code: got: %d, want: 0\n%s", code, stderr.String())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "main")); err != nil {
+		t.Errorf("the built executable doesn't exist: %v", err)
+	}
+}
+
+func TestEnvAndClean(t *testing.T) {
+	patch, err := filepath.Abs("../../testdata/sync/additional_file_by_uwagaki.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := createSyncModule(t, "example.com/cmd")
+	t.Chdir(dir)
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"env", "-replace=golang.org/x/sync:additional_file_by_uwagaki.go=" + patch}, &stdout, &stderr); code != 0 {
+		t.Fatalf("env: exit code: got: %d, want: 0\n%s", code, stderr.String())
+	}
+	envDir := strings.TrimSpace(stdout.String())
+	if _, err := os.Stat(envDir); err != nil {
+		t.Fatalf("the environment doesn't exist: %v", err)
+	}
+
+	stdout.Reset()
+	if code := run([]string{"diff", "-env", envDir}, &stdout, &stderr); code != 0 {
+		t.Fatalf("diff: exit code: got: %d, want: 0\n%s", code, stderr.String())
+	}
+	if got, want := stdout.String(), "+++ b/golang.org/x/sync@v0.11.0/additional_file_by_uwagaki.go\n"; !strings.Contains(got, want) {
+		t.Errorf("diff: got: %q, want: includes %q", got, want)
+	}
+
+	// A directory with a manifest is not an environment.
+	if err := os.WriteFile(filepath.Join(dir, "uwagaki.json"), []byte(`{"packages": ["."]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if code := run([]string{"clean", dir}, &stdout, &stderr); code != 1 {
+		t.Errorf("clean: exit code: got: %d, want: 1", code)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "main.go")); err != nil {
+		t.Errorf("the non-environment directory must not be removed: %v", err)
+	}
+
+	if code := run([]string{"clean", envDir}, &stdout, &stderr); code != 0 {
+		t.Fatalf("clean: exit code: got: %d, want: 0\n%s", code, stderr.String())
+	}
+	if _, err := os.Stat(envDir); !os.IsNotExist(err) {
+		t.Errorf("the environment must be removed: %v", err)
+	}
+}
+
+func TestExec(t *testing.T) {
+	patch, err := filepath.Abs("../../testdata/sync/additional_file_by_uwagaki.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := createSyncModule(t, "example.com/cmd")
+	if err := os.MkdirAll(filepath.Join(dir, "scripts"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "scripts", "check.sh"), []byte("#!/bin/sh\necho checked \"$@\"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(dir)
+
+	replace := "-replace=golang.org/x/sync:additional_file_by_uwagaki.go=" + patch
+
+	testCases := []struct {
+		args   []string
+		code   int
+		stdout string
+	}{
+		{
+			// '.' is translated to the package path in the environment.
+			args:   []string{"exec", replace, ".", "--", "go", "run", "."},
+			code:   0,
+			stdout: "Hello, Uwagaki (sync)!\n",
+		},
+		{
+			args:   []string{"exec", replace, "-layout=modfile", "--", "go", "run", "."},
+			code:   0,
+			stdout: "Hello, Uwagaki (sync)!\n",
+		},
+		{
+			// A relative command path is resolved against the current directory, not the environment.
+			args:   []string{"exec", replace, ".", "--", "./scripts/check.sh", "."},
+			code:   0,
+			stdout: "checked example.com/cmd\n",
+		},
+		{
+			// The exit code of the command is propagated.
+			args: []string{"exec", "--", "sh", "-c", "exit 3"},
+			code: 3,
+		},
+		{
+			args: []string{"exec", "."},
+			code: 2,
+		},
+		{
+			args: []string{"exec", ".", "--"},
+			code: 2,
+		},
+	}
+	for _, tc := range testCases {
+		var stdout, stderr bytes.Buffer
+		code := run(tc.args, &stdout, &stderr)
+		if got, want := code, tc.code; got != want {
+			t.Errorf("run(%q): exit code: got: %d, want: %d\n%s", tc.args, got, want, stderr.String())
+		}
+		if got, want := stdout.String(), tc.stdout; got != want {
+			t.Errorf("run(%q): stdout: got: %q, want: %q", tc.args, got, want)
+		}
+	}
+}
+
+func TestTool(t *testing.T) {
+	patch, err := filepath.Abs("../../testdata/sync/additional_file_by_uwagaki.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := createSyncModule(t, "example.com/cmd")
+	if err := os.MkdirAll(filepath.Join(dir, "hello"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "hello", "main.go"), mustReadFile("../../testdata/stringer/main.go"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(dir)
+
+	replace := "-replace=golang.org/x/sync:additional_file_by_uwagaki.go=" + patch
+
+	testCases := []struct {
+		args   []string
+		code   int
+		stdout string
+	}{
+		{
+			args:   []string{"tool", replace, "-tool", "example.com/cmd/hello", "hello"},
+			code:   0,
+			stdout: "Hello, Uwagaki (sync)!\n",
+		},
+		{
+			args:   []string{"tool", replace, "-tool=example.com/cmd/hello", "--", "example.com/cmd/hello", "arg"},
+			code:   0,
+			stdout: "Hello, Uwagaki (sync)!\n",
+		},
+		{
+			// The tool doesn't exist.
+			args: []string{"tool", replace, "hello"},
+			code: 1,
+		},
+		{
+			args: []string{"tool", replace},
+			code: 2,
+		},
+	}
+	for _, tc := range testCases {
+		var stdout, stderr bytes.Buffer
+		code := run(tc.args, &stdout, &stderr)
+		if got, want := code, tc.code; got != want {
+			t.Errorf("run(%q): exit code: got: %d, want: %d\n%s", tc.args, got, want, stderr.String())
+		}
+		if got, want := stdout.String(), tc.stdout; got != want {
+			t.Errorf("run(%q): stdout: got: %q, want: %q", tc.args, got, want)
+		}
+	}
+}
+
+func TestGC(t *testing.T) {
+	dir := t.TempDir()
+	stale := filepath.Join(dir, "uwagaki-stale")
+	if err := os.Mkdir(stale, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// Write metadata of a detached environment created long ago.
+	created := time.Now().Add(-48 * time.Hour).Format(time.RFC3339)
+	if err := os.WriteFile(filepath.Join(stale, ".uwagaki-env.json"), []byte(`{"Format": 1, "Created": "`+created+`"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// A directory without metadata is not removed.
+	if err := os.Mkdir(filepath.Join(dir, "uwagaki-foo"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		args   []string
+		code   int
+		stdout string
+		exists bool
+	}{
+		{
+			args:   []string{"gc", "-dir", dir, "-age=0"},
+			code:   0,
+			stdout: "",
+			exists: true,
+		},
+		{
+			args:   []string{"gc", "-dir", dir, "-n"},
+			code:   0,
+			stdout: stale + "\n",
+			exists: true,
+		},
+		{
+			args:   []string{"gc", "-age", "-1h", "-dir", dir},
+			code:   2,
+			exists: true,
+		},
+		{
+			args:   []string{"gc", dir},
+			code:   2,
+			exists: true,
+		},
+		{
+			args:   []string{"gc", "-dir=" + dir},
+			code:   0,
+			stdout: stale + "\n",
+			exists: false,
+		},
+	}
+	for _, tc := range testCases {
+		var stdout, stderr bytes.Buffer
+		if got, want := run(tc.args, &stdout, &stderr), tc.code; got != want {
+			t.Errorf("run(%q): exit code: got: %d, want: %d\n%s", tc.args, got, want, stderr.String())
+		}
+		if got, want := stdout.String(), tc.stdout; got != want {
+			t.Errorf("run(%q): stdout: got: %q, want: %q", tc.args, got, want)
+		}
+		if _, err := os.Stat(stale); (err == nil) != tc.exists {
+			t.Errorf("run(%q): %s exists: got: %t, want: %t", tc.args, stale, err == nil, tc.exists)
+		}
+	}
+}
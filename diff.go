@@ -0,0 +1,70 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2025 Hajime Hoshi
+
+package uwagaki
+
+import (
+	"io"
+	"maps"
+	"slices"
+
+	"github.com/hajimehoshi/uwagaki/internal/diff"
+)
+
+// Diff writes a unified diff between the original modules and the modules copied to the environment.
+//
+// Diff compares the whole trees of the copied modules, so files edited in the environment directly are also reported.
+// Added and deleted files are compared with /dev/null.
+// The file names in the headers are like 'a/golang.org/x/sync@v0.11.0/errgroup/errgroup.go' and 'b/golang.org/x/sync@v0.11.0/errgroup/errgroup.go'.
+// The modules are sorted by their paths.
+func (e *Environment) Diff(w io.Writer) error {
+	for _, mod := range slices.Sorted(maps.Keys(e.modules)) {
+		m := e.modules[mod]
+		names, err := moduleFileUnion(m)
+		if err != nil {
+			return err
+		}
+
+		prefix := mod
+		if m.version != "" {
+			prefix += "@" + m.version
+		}
+		for _, name := range names {
+			orig, origExists, err := readModuleFile(m.origDir, name)
+			if err != nil {
+				return err
+			}
+			current, currentExists, err := readModuleFile(m.dir, name)
+			if err != nil {
+				return err
+			}
+			oldName := "a/" + prefix + "/" + name
+			if !origExists {
+				oldName = diff.DevNull
+			}
+			newName := "b/" + prefix + "/" + name
+			if !currentExists {
+				newName = diff.DevNull
+			}
+			if _, err := w.Write(diff.Unified(oldName, newName, orig, current)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// moduleFileUnion returns the sorted union of the files in the original module and the copied module.
+func moduleFileUnion(m *envModule) ([]string, error) {
+	origFiles, err := listModuleFiles(m.origDir)
+	if err != nil {
+		return nil, err
+	}
+	files, err := listModuleFiles(m.dir)
+	if err != nil {
+		return nil, err
+	}
+	names := slices.Concat(origFiles, files)
+	slices.Sort(names)
+	return slices.Compact(names), nil
+}
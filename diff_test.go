@@ -0,0 +1,76 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2025 Hajime Hoshi
+
+package uwagaki_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hajimehoshi/uwagaki"
+)
+
+func TestEnvironmentDiff(t *testing.T) {
+	dir := createSyncModule(t, "example.com/diff")
+	t.Chdir(dir)
+
+	env, err := uwagaki.NewEnvironment([]string{"."}, []uwagaki.ReplaceItem{
+		{
+			Mod:     "golang.org/x/sync",
+			Path:    "additional_file_by_uwagaki.go",
+			Content: []byte("package sync\n"),
+		},
+		{
+			Mod:    "golang.org/x/sync",
+			Path:   "errgroup/go120_test.go",
+			Delete: true,
+		},
+		{
+			Mod:     "golang.org/x/sync",
+			Path:    "empty_file_by_uwagaki.go",
+			Content: []byte{},
+		},
+		{
+			Mod:  "golang.org/x/sync",
+			Path: "errgroup/errgroup.go",
+			Transform: func(path string, content []byte) ([]byte, error) {
+				return bytes.Replace(content, []byte("// A negative value indicates no limit.\n"), []byte("// A negative value indicates no limit (patched).\n"), 1), nil
+			},
+		},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer env.Close()
+
+	// An edit in the environment directly is also reported.
+	if err := os.WriteFile(filepath.Join(env.Dir(), "mod", "golang.org", "x", "sync+v0.11.0", "semaphore", "new.go"), []byte("package semaphore\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := env.Diff(&buf); err != nil {
+		t.Fatal(err)
+	}
+	got := buf.String()
+
+	for _, want := range []string{
+		"--- /dev/null\n+++ b/golang.org/x/sync@v0.11.0/additional_file_by_uwagaki.go\n@@ -0,0 +1 @@\n+package sync\n",
+		"--- a/golang.org/x/sync@v0.11.0/errgroup/go120_test.go\n+++ /dev/null\n",
+		"--- a/golang.org/x/sync@v0.11.0/errgroup/errgroup.go\n+++ b/golang.org/x/sync@v0.11.0/errgroup/errgroup.go\n",
+		"-// A negative value indicates no limit.\n+// A negative value indicates no limit (patched).\n",
+		"--- /dev/null\n+++ b/golang.org/x/sync@v0.11.0/semaphore/new.go\n",
+		// An added empty file is reported with only the header.
+		"--- /dev/null\n+++ b/golang.org/x/sync@v0.11.0/empty_file_by_uwagaki.go\n--- ",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("the diff must include %q:\n%s", want, got)
+		}
+	}
+	if got, want := strings.Count(got, "\n--- ")+1, 5; got != want {
+		t.Errorf("the number of files: got: %d, want: %d", got, want)
+	}
+}
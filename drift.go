@@ -0,0 +1,101 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2025 Hajime Hoshi
+
+package uwagaki
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const hashPrefix = "sha256:"
+
+// Hash returns the hash of the content in the format of ReplaceItem.BaseHash, like 'sha256:<hex>'.
+func Hash(content []byte) string {
+	h := sha256.Sum256(content)
+	return hashPrefix + hex.EncodeToString(h[:])
+}
+
+// isValidHash reports whether the string is in the format of Hash.
+func isValidHash(str string) bool {
+	h, ok := strings.CutPrefix(str, hashPrefix)
+	if !ok || len(h) != sha256.Size*2 {
+		return false
+	}
+	if strings.ToLower(h) != h {
+		return false
+	}
+	_, err := hex.DecodeString(h)
+	return err == nil
+}
+
+// DriftError is an error for an original file that differs from what a ReplaceItem expects by BaseHash.
+// This happens typically when the module is upgraded and the replaced file is changed in upstream.
+type DriftError struct {
+	// Mod is the module path.
+	Mod string
+
+	// Version is the module version in the environment.
+	// Version is empty for a main module.
+	Version string
+
+	// Path is the slash-separated file path in the module.
+	Path string
+
+	// Want is the expected hash specified by ReplaceItem.BaseHash.
+	Want string
+
+	// Got is the actual hash of the original file.
+	// Got is empty if the original file doesn't exist.
+	Got string
+}
+
+// Error implements error.
+func (e *DriftError) Error() string {
+	got := e.Got
+	if got == "" {
+		got = "(not found)"
+	}
+	mod := e.Mod
+	if e.Version != "" {
+		mod += "@" + e.Version
+	}
+	return fmt.Sprintf("uwagaki: the original file was changed: %s: %s: want: %s, got: %s", mod, e.Path, e.Want, got)
+}
+
+// checkDrift checks the original file of the item with ReplaceItem.BaseHash.
+// checkDrift calls onDrift if the file differs. If onDrift is nil, checkDrift returns the *DriftError.
+func checkDrift(r *ReplaceItem, m *envModule, onDrift func(*DriftError) error) error {
+	if r.BaseHash == "" {
+		return nil
+	}
+
+	var got string
+	content, err := os.ReadFile(filepath.Join(m.origDir, filepath.FromSlash(r.Path)))
+	if err == nil {
+		got = Hash(content)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if got == r.BaseHash {
+		return nil
+	}
+
+	driftErr := &DriftError{
+		Mod:     r.Mod,
+		Version: m.version,
+		Path:    r.Path,
+		Want:    r.BaseHash,
+		Got:     got,
+	}
+	if onDrift == nil {
+		return driftErr
+	}
+	return onDrift(driftErr)
+}
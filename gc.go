@@ -0,0 +1,85 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2025 Hajime Hoshi
+
+package uwagaki
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// tempDirPrefix is the prefix of a temporary environment directory.
+const tempDirPrefix = "uwagaki-"
+
+// GCOptions represents options for GC.
+type GCOptions struct {
+	// Dir is the directory including environments.
+	// The default is os.TempDir(), where NewEnvironment creates environments without Options.Dir.
+	Dir string
+
+	// MaxAge is the maximum age of environments.
+	// An environment older than MaxAge is removed even if its creator process is still running.
+	// If MaxAge is 0, environments are not removed by their ages.
+	MaxAge time.Duration
+
+	// DryRun indicates that GC reports environments to remove without removing them.
+	DryRun bool
+}
+
+// GC removes stale environments in the directory and returns the removed directories.
+//
+// GC finds directories with the prefix 'uwagaki-', and removes an environment
+// if its creator process is no longer running on this host, or it is older than GCOptions.MaxAge.
+// A detached environment (see Options.Detached) is removed only by its age.
+// A directory without valid metadata of an environment is never removed, even if its name has the prefix.
+//
+// options can be nil.
+func GC(options *GCOptions) ([]string, error) {
+	if options == nil {
+		options = &GCOptions{}
+	}
+	dir := options.Dir
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	hostname, _ := os.Hostname()
+	now := time.Now()
+	isOld := func(t time.Time) bool {
+		return options.MaxAge > 0 && now.Sub(t) > options.MaxAge
+	}
+
+	var removed []string
+	var errs []error
+	for _, entry := range entries {
+		// A symbolic link is not followed.
+		if !entry.IsDir() || !strings.HasPrefix(entry.Name(), tempDirPrefix) {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+
+		md, err := readMetadata(path)
+		if err != nil {
+			continue
+		}
+		if !isOld(md.Created) && (md.PID == 0 || md.Hostname != hostname || processExists(md.PID)) {
+			continue
+		}
+
+		if !options.DryRun {
+			if err := os.RemoveAll(path); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+		}
+		removed = append(removed, path)
+	}
+	return removed, errors.Join(errs...)
+}
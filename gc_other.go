@@ -0,0 +1,12 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2025 Hajime Hoshi
+
+//go:build !unix && !windows
+
+package uwagaki
+
+// processExists reports whether the process of the pid is running.
+// The process is always treated as running, as there is no way to check it.
+func processExists(pid int) bool {
+	return true
+}
@@ -0,0 +1,144 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2025 Hajime Hoshi
+
+package uwagaki_test
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hajimehoshi/uwagaki"
+)
+
+// writeMetadata writes a metadata file of a fake environment.
+func writeMetadata(t *testing.T, dir string, pid int, created time.Time) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := json.Marshal(map[string]any{
+		"Format":   1,
+		"PID":      pid,
+		"Hostname": hostname,
+		"Created":  created,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ".uwagaki-env.json"), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGC(t *testing.T) {
+	dir := createSyncModule(t, "example.com/gc")
+	t.Chdir(dir)
+
+	env, err := uwagaki.NewEnvironment([]string{"."}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer env.Close()
+	if got, want := filepath.Base(env.Dir()), "uwagaki-"; !strings.HasPrefix(got, want) {
+		t.Errorf("environment directory: got: %s, want prefix: %s", got, want)
+	}
+
+	gcDir := t.TempDir()
+	live, err := uwagaki.NewEnvironment([]string{"."}, nil, &uwagaki.Options{
+		Dir: filepath.Join(gcDir, "uwagaki-live"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer live.Close()
+
+	// Get a process ID that is no longer used.
+	cmd := exec.Command("go", "version")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	deadPID := cmd.ProcessState.Pid()
+
+	now := time.Now()
+	writeMetadata(t, filepath.Join(gcDir, "uwagaki-dead"), deadPID, now)
+	writeMetadata(t, filepath.Join(gcDir, "uwagaki-detached"), 0, now)
+	writeMetadata(t, filepath.Join(gcDir, "uwagaki-old-detached"), 0, now.Add(-48*time.Hour))
+	writeMetadata(t, filepath.Join(gcDir, "uwagaki-old-live"), os.Getpid(), now.Add(-48*time.Hour))
+	writeMetadata(t, filepath.Join(gcDir, "other-dead"), deadPID, now)
+	writeMetadata(t, filepath.Join(gcDir, "uwagaki-no-created"), deadPID, time.Time{})
+
+	// Directories without valid metadata are never removed, even if they are old.
+	if err := os.Mkdir(filepath.Join(gcDir, "uwagaki-old-no-metadata"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(gcDir, "uwagaki-old-manifest"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(gcDir, "uwagaki-old-manifest", "uwagaki.json"), []byte(`{"packages": ["."]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"uwagaki-old-no-metadata", "uwagaki-old-manifest"} {
+		if err := os.Chtimes(filepath.Join(gcDir, name), now.Add(-48*time.Hour), now.Add(-48*time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	testCases := []struct {
+		options *uwagaki.GCOptions
+		removed []string
+	}{
+		{
+			options: &uwagaki.GCOptions{Dir: gcDir, DryRun: true},
+			removed: []string{"uwagaki-dead"},
+		},
+		{
+			options: &uwagaki.GCOptions{Dir: gcDir, MaxAge: 24 * time.Hour, DryRun: true},
+			removed: []string{"uwagaki-dead", "uwagaki-old-detached", "uwagaki-old-live"},
+		},
+		{
+			options: &uwagaki.GCOptions{Dir: gcDir},
+			removed: []string{"uwagaki-dead"},
+		},
+		{
+			// The removed directory is not reported again.
+			options: &uwagaki.GCOptions{Dir: gcDir},
+			removed: nil,
+		},
+	}
+	for _, tc := range testCases {
+		removed, err := uwagaki.GC(tc.options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, r := range removed {
+			names = append(names, filepath.Base(r))
+		}
+		slices.Sort(names)
+		if got, want := names, tc.removed; !slices.Equal(got, want) {
+			t.Errorf("GC(%+v): got: %v, want: %v", tc.options, got, want)
+		}
+		if tc.options.DryRun {
+			continue
+		}
+		for _, r := range removed {
+			if _, err := os.Stat(r); !os.IsNotExist(err) {
+				t.Errorf("%s must be removed: %v", r, err)
+			}
+		}
+	}
+
+	// The live environment is still available.
+	if _, err := uwagaki.OpenEnvironment(live.Dir()); err != nil {
+		t.Error(err)
+	}
+}
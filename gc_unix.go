@@ -0,0 +1,19 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2025 Hajime Hoshi
+
+//go:build unix
+
+package uwagaki
+
+import (
+	"errors"
+	"syscall"
+)
+
+// processExists reports whether the process of the pid is running.
+func processExists(pid int) bool {
+	// The signal 0 checks only the existence of the process.
+	err := syscall.Kill(pid, 0)
+	// EPERM means the process exists but is owned by another user.
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
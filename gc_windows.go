@@ -0,0 +1,33 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2025 Hajime Hoshi
+
+//go:build windows
+
+package uwagaki
+
+import (
+	"errors"
+	"syscall"
+)
+
+const (
+	_PROCESS_QUERY_LIMITED_INFORMATION = 0x1000
+	_STILL_ACTIVE                      = 259
+)
+
+// processExists reports whether the process of the pid is running.
+func processExists(pid int) bool {
+	h, err := syscall.OpenProcess(_PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	if err != nil {
+		// ERROR_ACCESS_DENIED means the process exists but is owned by another user.
+		return errors.Is(err, syscall.ERROR_ACCESS_DENIED)
+	}
+	defer syscall.CloseHandle(h)
+
+	// A handle of an exited process is still available while another process has it.
+	var code uint32
+	if err := syscall.GetExitCodeProcess(h, &code); err != nil {
+		return true
+	}
+	return code == _STILL_ACTIVE
+}
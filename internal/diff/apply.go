@@ -0,0 +1,162 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2025 Hajime Hoshi
+
+package diff
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var hunkHeaderRe = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+type patchHunk struct {
+	oldStart int
+	old      []string
+	new      []string
+}
+
+// Apply applies a unified diff for one file to the content.
+//
+// The headers like '---' and '+++' are optional.
+// If the context of a hunk doesn't match at the position in the hunk header, the nearest matching position is used.
+func Apply(content []byte, patch []byte) ([]byte, error) {
+	hunks, err := parsePatch(patch)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := Lines(content)
+	var result []string
+	pos := 0
+	for i, h := range hunks {
+		start, ok := findHunk(lines, h, pos)
+		if !ok {
+			return nil, fmt.Errorf("diff: hunk #%d doesn't match", i+1)
+		}
+		result = append(result, lines[pos:start]...)
+		result = append(result, h.new...)
+		pos = start + len(h.old)
+	}
+	result = append(result, lines[pos:]...)
+	return []byte(strings.Join(result, "")), nil
+}
+
+// findHunk returns the position of the hunk's old lines in the lines at or after pos.
+func findHunk(lines []string, h patchHunk, pos int) (int, bool) {
+	matches := func(start int) bool {
+		return start >= pos && start+len(h.old) <= len(lines) && slices.Equal(lines[start:start+len(h.old)], h.old)
+	}
+	for d := 0; d <= len(lines); d++ {
+		if matches(h.oldStart + d) {
+			return h.oldStart + d, true
+		}
+		if d > 0 && matches(h.oldStart-d) {
+			return h.oldStart - d, true
+		}
+	}
+	return 0, false
+}
+
+func parsePatch(patch []byte) ([]patchHunk, error) {
+	var hunks []patchHunk
+	var h *patchHunk
+	var oldLeft, newLeft int
+	var files int
+
+	// trimLast removes the newline of the last line for '\ No newline at end of file'.
+	var trimLast func()
+	trim := func(lines []string) {
+		lines[len(lines)-1] = strings.TrimSuffix(lines[len(lines)-1], "\n")
+	}
+
+	for i, l := range Lines(patch) {
+		lineNum := i + 1
+		if strings.HasPrefix(l, `\`) {
+			if trimLast != nil {
+				trimLast()
+				trimLast = nil
+			}
+			continue
+		}
+
+		if h == nil || (oldLeft == 0 && newLeft == 0) {
+			if strings.HasPrefix(l, "--- ") {
+				files++
+				if files > 1 {
+					return nil, fmt.Errorf("diff: line %d: a patch must be for one file", lineNum)
+				}
+				continue
+			}
+			m := hunkHeaderRe.FindStringSubmatch(l)
+			if m == nil {
+				if len(hunks) > 0 {
+					return nil, fmt.Errorf("diff: line %d: unexpected line after hunks: %q", lineNum, l)
+				}
+				// Skip headers.
+				continue
+			}
+			atoi := func(s string) int {
+				if s == "" {
+					return 1
+				}
+				n, _ := strconv.Atoi(s)
+				return n
+			}
+			oldStart, oldLen, newLen := atoi(m[1]), atoi(m[2]), atoi(m[4])
+			// An empty range is represented by the line before the range.
+			if oldLen > 0 {
+				oldStart--
+			}
+			hunks = append(hunks, patchHunk{oldStart: oldStart})
+			h = &hunks[len(hunks)-1]
+			oldLeft, newLeft = oldLen, newLen
+			trimLast = nil
+			continue
+		}
+
+		if l == "\n" {
+			// Some editors remove trailing spaces of context lines.
+			l = " \n"
+		}
+		if !strings.HasSuffix(l, "\n") {
+			l += "\n"
+		}
+		hunk := h
+		switch l[0] {
+		case ' ':
+			h.old = append(h.old, l[1:])
+			h.new = append(h.new, l[1:])
+			oldLeft--
+			newLeft--
+			trimLast = func() {
+				trim(hunk.old)
+				trim(hunk.new)
+			}
+		case '-':
+			h.old = append(h.old, l[1:])
+			oldLeft--
+			trimLast = func() {
+				trim(hunk.old)
+			}
+		case '+':
+			h.new = append(h.new, l[1:])
+			newLeft--
+			trimLast = func() {
+				trim(hunk.new)
+			}
+		default:
+			return nil, fmt.Errorf("diff: line %d: invalid line in a hunk: %q", lineNum, l)
+		}
+		if oldLeft < 0 || newLeft < 0 {
+			return nil, fmt.Errorf("diff: line %d: too many lines in a hunk", lineNum)
+		}
+	}
+	if oldLeft != 0 || newLeft != 0 {
+		return nil, fmt.Errorf("diff: unexpected end of a hunk")
+	}
+	return hunks, nil
+}
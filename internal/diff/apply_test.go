@@ -0,0 +1,76 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2025 Hajime Hoshi
+
+package diff_test
+
+import (
+	"testing"
+
+	"github.com/hajimehoshi/uwagaki/internal/diff"
+)
+
+func TestApply(t *testing.T) {
+	testCases := []struct {
+		name string
+		old  string
+		new  string
+	}{
+		{
+			name: "change",
+			old:  "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n",
+			new:  "1\n2\n3\n4\nfive\n6\n7\n8\n9\n10\n11\n12\nthirteen\n",
+		},
+		{
+			name: "added",
+			old:  "",
+			new:  "a\nb\n",
+		},
+		{
+			name: "removed",
+			old:  "a\nb\n",
+			new:  "",
+		},
+		{
+			name: "no newline",
+			old:  "a\nb",
+			new:  "a\nc",
+		},
+		{
+			name: "add newline",
+			old:  "a\nb",
+			new:  "a\nb\n",
+		},
+		{
+			name: "remove newline",
+			old:  "a\nb\n",
+			new:  "a\nb",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			patch := diff.Unified("a", "b", []byte(tc.old), []byte(tc.new))
+			got, err := diff.Apply([]byte(tc.old), patch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tc.new {
+				t.Errorf("got: %q, want: %q", got, tc.new)
+			}
+		})
+	}
+}
+
+func TestApplyOffset(t *testing.T) {
+	patch := []byte("@@ -2,3 +2,3 @@\n b\n-c\n+C\n d\n")
+	got, err := diff.Apply([]byte("x\ny\na\nb\nc\nd\ne\n"), patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "x\ny\na\nb\nC\nd\ne\n"; string(got) != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+
+	if _, err := diff.Apply([]byte("a\nb\nd\n"), patch); err == nil {
+		t.Errorf("Apply with an unmatched hunk must fail")
+	}
+}
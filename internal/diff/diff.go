@@ -0,0 +1,206 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2025 Hajime Hoshi
+
+// Package diff provides line-based diffs and three-way merges.
+package diff
+
+import (
+	"bytes"
+	"slices"
+	"sort"
+)
+
+// Hunk represents a changed region.
+// Old lines [OldStart, OldEnd) are replaced with new lines [NewStart, NewEnd).
+type Hunk struct {
+	OldStart int
+	OldEnd   int
+	NewStart int
+	NewEnd   int
+}
+
+// Lines splits the content into lines.
+// Each line includes its trailing newline if exists.
+func Lines(content []byte) []string {
+	var lines []string
+	for len(content) > 0 {
+		i := bytes.IndexByte(content, '\n')
+		if i < 0 {
+			lines = append(lines, string(content))
+			break
+		}
+		lines = append(lines, string(content[:i+1]))
+		content = content[i+1:]
+	}
+	return lines
+}
+
+// Diff returns the changed regions between the old lines and the new lines.
+//
+// Diff matches lines that are unique in both sides first like the patience diff algorithm,
+// and then matches the rest lines by the longest common subsequence if the region is small enough.
+func Diff(old, new []string) []Hunk {
+	var pairs []pair
+	match(old, new, 0, len(old), 0, len(new), &pairs)
+
+	var hunks []Hunk
+	i, j := 0, 0
+	for _, p := range append(pairs, pair{len(old), len(new)}) {
+		if p.i > i || p.j > j {
+			hunks = append(hunks, Hunk{
+				OldStart: i,
+				OldEnd:   p.i,
+				NewStart: j,
+				NewEnd:   p.j,
+			})
+		}
+		i, j = p.i+1, p.j+1
+	}
+	return hunks
+}
+
+// pair represents a matched line.
+type pair struct {
+	i int
+	j int
+}
+
+// maxLCSSize is the maximum size of a region to compute the longest common subsequence.
+const maxLCSSize = 1 << 20
+
+// match appends the matched lines in old[olo:ohi] and new[nlo:nhi] to pairs in order.
+func match(old, new []string, olo, ohi, nlo, nhi int, pairs *[]pair) {
+	// Match the common prefix.
+	for olo < ohi && nlo < nhi && old[olo] == new[nlo] {
+		*pairs = append(*pairs, pair{olo, nlo})
+		olo++
+		nlo++
+	}
+	// Match the common suffix later to keep the order.
+	var suffix int
+	for olo < ohi-suffix && nlo < nhi-suffix && old[ohi-suffix-1] == new[nhi-suffix-1] {
+		suffix++
+	}
+	ohi -= suffix
+	nhi -= suffix
+	defer func() {
+		for k := range suffix {
+			*pairs = append(*pairs, pair{ohi + k, nhi + k})
+		}
+	}()
+
+	if olo == ohi || nlo == nhi {
+		return
+	}
+
+	if anchors := uniqueAnchors(old, new, olo, ohi, nlo, nhi); len(anchors) > 0 {
+		i, j := olo, nlo
+		for _, a := range anchors {
+			match(old, new, i, a.i, j, a.j, pairs)
+			*pairs = append(*pairs, a)
+			i, j = a.i+1, a.j+1
+		}
+		match(old, new, i, ohi, j, nhi, pairs)
+		return
+	}
+
+	if (ohi-olo)*(nhi-nlo) <= maxLCSSize {
+		lcs(old, new, olo, ohi, nlo, nhi, pairs)
+	}
+}
+
+// uniqueAnchors returns the longest sequence of lines that are unique in both regions, in order.
+func uniqueAnchors(old, new []string, olo, ohi, nlo, nhi int) []pair {
+	type count struct {
+		old  int
+		new  int
+		oldI int
+		newJ int
+	}
+	counts := map[string]*count{}
+	for i := olo; i < ohi; i++ {
+		c, ok := counts[old[i]]
+		if !ok {
+			c = &count{}
+			counts[old[i]] = c
+		}
+		c.old++
+		c.oldI = i
+	}
+	for j := nlo; j < nhi; j++ {
+		c, ok := counts[new[j]]
+		if !ok {
+			continue
+		}
+		c.new++
+		c.newJ = j
+	}
+
+	var candidates []pair
+	for _, c := range counts {
+		if c.old == 1 && c.new == 1 {
+			candidates = append(candidates, pair{c.oldI, c.newJ})
+		}
+	}
+	slices.SortFunc(candidates, func(a, b pair) int {
+		return a.i - b.i
+	})
+
+	// Find the longest increasing subsequence of j by patience sorting.
+	var tops []int // tops[k] is the index of the candidate at the top of the k-th pile.
+	prevs := make([]int, len(candidates))
+	for idx, c := range candidates {
+		k := sort.Search(len(tops), func(k int) bool {
+			return candidates[tops[k]].j > c.j
+		})
+		if k > 0 {
+			prevs[idx] = tops[k-1]
+		} else {
+			prevs[idx] = -1
+		}
+		if k == len(tops) {
+			tops = append(tops, idx)
+		} else {
+			tops[k] = idx
+		}
+	}
+	if len(tops) == 0 {
+		return nil
+	}
+	anchors := make([]pair, len(tops))
+	for k, idx := len(tops)-1, tops[len(tops)-1]; idx >= 0; k, idx = k-1, prevs[idx] {
+		anchors[k] = candidates[idx]
+	}
+	return anchors
+}
+
+// lcs appends the lines of the longest common subsequence of the regions to pairs in order.
+func lcs(old, new []string, olo, ohi, nlo, nhi int, pairs *[]pair) {
+	n, m := ohi-olo, nhi-nlo
+	// table[i][j] is the length of the LCS of old[olo+i:ohi] and new[nlo+j:nhi].
+	table := make([][]int, n+1)
+	for i := range table {
+		table[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if old[olo+i] == new[nlo+j] {
+				table[i][j] = table[i+1][j+1] + 1
+			} else {
+				table[i][j] = max(table[i+1][j], table[i][j+1])
+			}
+		}
+	}
+	for i, j := 0, 0; i < n && j < m; {
+		switch {
+		case old[olo+i] == new[nlo+j]:
+			*pairs = append(*pairs, pair{olo + i, nlo + j})
+			i++
+			j++
+		case table[i+1][j] >= table[i][j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+}
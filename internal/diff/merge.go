@@ -0,0 +1,141 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2025 Hajime Hoshi
+
+package diff
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+)
+
+// Labels represents labels for conflict markers.
+type Labels struct {
+	Ours   string
+	Base   string
+	Theirs string
+}
+
+// Merge merges the changes from base to ours and the changes from base to theirs.
+//
+// Merge returns the merged content and the number of conflicts.
+// A conflict is a region changed differently in both sides, and is written with conflict markers in the diff3 style:
+//
+//	<<<<<<< ours
+//	...
+//	||||||| base
+//	...
+//	=======
+//	...
+//	>>>>>>> theirs
+//
+// Changes touching each other are also treated as conflicts.
+func Merge(base, ours, theirs []byte, labels Labels) ([]byte, int) {
+	baseLines := Lines(base)
+	oursLines := Lines(ours)
+	theirsLines := Lines(theirs)
+
+	type sideHunk struct {
+		Hunk
+		theirs bool
+	}
+	var hunks []sideHunk
+	for _, h := range Diff(baseLines, oursLines) {
+		hunks = append(hunks, sideHunk{Hunk: h})
+	}
+	for _, h := range Diff(baseLines, theirsLines) {
+		hunks = append(hunks, sideHunk{Hunk: h, theirs: true})
+	}
+	slices.SortStableFunc(hunks, func(a, b sideHunk) int {
+		if a.OldStart != b.OldStart {
+			return a.OldStart - b.OldStart
+		}
+		return a.OldEnd - b.OldEnd
+	})
+
+	// apply returns the lines of the side for base[lo:hi] with the hunks applied.
+	apply := func(lines []string, group []sideHunk, theirs bool, lo, hi int) []string {
+		var result []string
+		pos := lo
+		for _, h := range group {
+			if h.theirs != theirs {
+				continue
+			}
+			result = append(result, baseLines[pos:h.OldStart]...)
+			result = append(result, lines[h.NewStart:h.NewEnd]...)
+			pos = h.OldEnd
+		}
+		return append(result, baseLines[pos:hi]...)
+	}
+
+	var out bytes.Buffer
+	var conflicts int
+	pos := 0
+	for len(hunks) > 0 {
+		// Group the overlapping or touching hunks.
+		lo, hi := hunks[0].OldStart, hunks[0].OldEnd
+		n := 1
+		for n < len(hunks) && hunks[n].OldStart <= hi {
+			hi = max(hi, hunks[n].OldEnd)
+			n++
+		}
+		group := hunks[:n]
+		hunks = hunks[n:]
+
+		writeLines(&out, baseLines[pos:lo])
+		pos = hi
+
+		var hasOurs, hasTheirs bool
+		for _, h := range group {
+			if h.theirs {
+				hasTheirs = true
+			} else {
+				hasOurs = true
+			}
+		}
+		o := apply(oursLines, group, false, lo, hi)
+		t := apply(theirsLines, group, true, lo, hi)
+		switch {
+		case !hasTheirs:
+			writeLines(&out, o)
+		case !hasOurs:
+			writeLines(&out, t)
+		case slices.Equal(o, t):
+			writeLines(&out, o)
+		default:
+			conflicts++
+			writeMarker(&out, "<<<<<<<", labels.Ours)
+			writeSection(&out, o)
+			writeMarker(&out, "|||||||", labels.Base)
+			writeSection(&out, baseLines[lo:hi])
+			writeMarker(&out, "=======", "")
+			writeSection(&out, t)
+			writeMarker(&out, ">>>>>>>", labels.Theirs)
+		}
+	}
+	writeLines(&out, baseLines[pos:])
+	return out.Bytes(), conflicts
+}
+
+func writeLines(out *bytes.Buffer, lines []string) {
+	for _, l := range lines {
+		out.WriteString(l)
+	}
+}
+
+// writeSection writes the lines in a conflict. A newline is added if the last line doesn't have it, not to break the marker.
+func writeSection(out *bytes.Buffer, lines []string) {
+	writeLines(out, lines)
+	if len(lines) > 0 && !strings.HasSuffix(lines[len(lines)-1], "\n") {
+		out.WriteByte('\n')
+	}
+}
+
+func writeMarker(out *bytes.Buffer, marker string, label string) {
+	out.WriteString(marker)
+	if label != "" {
+		out.WriteByte(' ')
+		out.WriteString(label)
+	}
+	out.WriteByte('\n')
+}
@@ -0,0 +1,103 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2025 Hajime Hoshi
+
+package diff_test
+
+import (
+	"testing"
+
+	"github.com/hajimehoshi/uwagaki/internal/diff"
+)
+
+func TestMerge(t *testing.T) {
+	testCases := []struct {
+		name      string
+		base      string
+		ours      string
+		theirs    string
+		want      string
+		conflicts int
+	}{
+		{
+			name:   "no changes",
+			base:   "a\nb\nc\n",
+			ours:   "a\nb\nc\n",
+			theirs: "a\nb\nc\n",
+			want:   "a\nb\nc\n",
+		},
+		{
+			name:   "ours only",
+			base:   "a\nb\nc\n",
+			ours:   "a\nB\nc\n",
+			theirs: "a\nb\nc\n",
+			want:   "a\nB\nc\n",
+		},
+		{
+			name:   "theirs only",
+			base:   "a\nb\nc\n",
+			ours:   "a\nb\nc\n",
+			theirs: "a\nb\nc\nd\n",
+			want:   "a\nb\nc\nd\n",
+		},
+		{
+			name:   "separate changes",
+			base:   "a\nb\nc\nd\ne\n",
+			ours:   "A\nb\nc\nd\ne\n",
+			theirs: "a\nb\nc\nd\nE\nf\n",
+			want:   "A\nb\nc\nd\nE\nf\n",
+		},
+		{
+			name:   "same changes",
+			base:   "a\nb\nc\n",
+			ours:   "a\nB\nc\n",
+			theirs: "a\nB\nc\n",
+			want:   "a\nB\nc\n",
+		},
+		{
+			name:   "repeated lines",
+			base:   "}\n}\nx\n}\n}\n",
+			ours:   "}\n}\nx\n}\n}\ny\n",
+			theirs: "w\n}\n}\nx\n}\n}\n",
+			want:   "w\n}\n}\nx\n}\n}\ny\n",
+		},
+		{
+			name:      "conflict",
+			base:      "a\nb\nc\n",
+			ours:      "a\nB\nc\n",
+			theirs:    "a\nBB\nc\n",
+			want:      "a\n<<<<<<< ours\nB\n||||||| base\nb\n=======\nBB\n>>>>>>> theirs\nc\n",
+			conflicts: 1,
+		},
+		{
+			name:      "conflict without newline",
+			base:      "a\nb",
+			ours:      "a\nB",
+			theirs:    "a\nBB",
+			want:      "a\n<<<<<<< ours\nB\n||||||| base\nb\n=======\nBB\n>>>>>>> theirs\n",
+			conflicts: 1,
+		},
+		{
+			name:      "added files",
+			base:      "",
+			ours:      "a\n",
+			theirs:    "b\n",
+			want:      "<<<<<<< ours\na\n||||||| base\n=======\nb\n>>>>>>> theirs\n",
+			conflicts: 1,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, conflicts := diff.Merge([]byte(tc.base), []byte(tc.ours), []byte(tc.theirs), diff.Labels{
+				Ours:   "ours",
+				Base:   "base",
+				Theirs: "theirs",
+			})
+			if string(got) != tc.want {
+				t.Errorf("got: %q, want: %q", got, tc.want)
+			}
+			if conflicts != tc.conflicts {
+				t.Errorf("conflicts: got: %d, want: %d", conflicts, tc.conflicts)
+			}
+		})
+	}
+}
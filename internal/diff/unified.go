@@ -0,0 +1,91 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2025 Hajime Hoshi
+
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// contextLines is the number of context lines in a unified diff.
+const contextLines = 3
+
+// DevNull is the name of an absent file in a unified diff.
+const DevNull = "/dev/null"
+
+// Unified returns a unified diff between the old content and the new content.
+//
+// oldName and newName are used in the header like 'a/foo.go' and 'b/foo.go'.
+// DevNull must be used for the absent side of an added or a removed file.
+// As an absent file is distinguished from an empty file by its name, adding or removing an empty file results in a diff with only the header.
+//
+// Unified returns nil if the contents are the same and neither or both of the files are absent.
+func Unified(oldName, newName string, old, new []byte) []byte {
+	if bytes.Equal(old, new) && (oldName == DevNull) == (newName == DevNull) {
+		return nil
+	}
+
+	var out bytes.Buffer
+	if bytes.IndexByte(old, 0) >= 0 || bytes.IndexByte(new, 0) >= 0 {
+		fmt.Fprintf(&out, "Binary files %s and %s differ\n", oldName, newName)
+		return out.Bytes()
+	}
+
+	fmt.Fprintf(&out, "--- %s\n", oldName)
+	fmt.Fprintf(&out, "+++ %s\n", newName)
+
+	oldLines := Lines(old)
+	newLines := Lines(new)
+	hunks := Diff(oldLines, newLines)
+	for len(hunks) > 0 {
+		// Group the hunks whose contexts overlap.
+		n := 1
+		for n < len(hunks) && hunks[n].OldStart-hunks[n-1].OldEnd <= 2*contextLines {
+			n++
+		}
+		group := hunks[:n]
+		hunks = hunks[n:]
+
+		first, last := group[0], group[len(group)-1]
+		oldStart := max(first.OldStart-contextLines, 0)
+		oldEnd := min(last.OldEnd+contextLines, len(oldLines))
+		newStart := first.NewStart - (first.OldStart - oldStart)
+		newEnd := last.NewEnd + (oldEnd - last.OldEnd)
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(oldStart, oldEnd), hunkRange(newStart, newEnd))
+
+		pos := oldStart
+		for _, h := range group {
+			writeUnifiedLines(&out, ' ', oldLines[pos:h.OldStart])
+			writeUnifiedLines(&out, '-', oldLines[h.OldStart:h.OldEnd])
+			writeUnifiedLines(&out, '+', newLines[h.NewStart:h.NewEnd])
+			pos = h.OldEnd
+		}
+		writeUnifiedLines(&out, ' ', oldLines[pos:oldEnd])
+	}
+	return out.Bytes()
+}
+
+// hunkRange returns a range in a hunk header like '1,3'.
+func hunkRange(start, end int) string {
+	switch end - start {
+	case 0:
+		// An empty range is represented by the line before the range.
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	default:
+		return fmt.Sprintf("%d,%d", start+1, end-start)
+	}
+}
+
+func writeUnifiedLines(out *bytes.Buffer, prefix byte, lines []string) {
+	for _, l := range lines {
+		out.WriteByte(prefix)
+		out.WriteString(l)
+		if !strings.HasSuffix(l, "\n") {
+			out.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
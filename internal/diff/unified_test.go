@@ -0,0 +1,83 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2025 Hajime Hoshi
+
+package diff_test
+
+import (
+	"cmp"
+	"testing"
+
+	"github.com/hajimehoshi/uwagaki/internal/diff"
+)
+
+func TestUnified(t *testing.T) {
+	testCases := []struct {
+		name    string
+		oldName string
+		newName string
+		old     string
+		new     string
+		want    string
+	}{
+		{
+			name: "same",
+			old:  "a\n",
+			new:  "a\n",
+			want: "",
+		},
+		{
+			name: "change",
+			old:  "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n",
+			new:  "1\n2\n3\n4\nfive\n6\n7\n8\n9\n10\n11\n12\nthirteen\n",
+			want: "--- a\n+++ b\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n@@ -10,3 +10,4 @@\n 10\n 11\n 12\n+thirteen\n",
+		},
+		{
+			name: "merged hunks",
+			old:  "1\n2\n3\n4\n5\n6\n7\n8\n",
+			new:  "one\n2\n3\n4\n5\n6\n7\neight\n",
+			want: "--- a\n+++ b\n@@ -1,8 +1,8 @@\n-1\n+one\n 2\n 3\n 4\n 5\n 6\n 7\n-8\n+eight\n",
+		},
+		{
+			name: "added",
+			old:  "",
+			new:  "a\nb\n",
+			want: "--- a\n+++ b\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name: "no newline",
+			old:  "a\nb",
+			new:  "a\nc",
+			want: "--- a\n+++ b\n@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+c\n\\ No newline at end of file\n",
+		},
+		{
+			name: "binary",
+			old:  "a\x00",
+			new:  "b\x00",
+			want: "Binary files a and b differ\n",
+		},
+		{
+			name:    "added empty",
+			oldName: "/dev/null",
+			want:    "--- /dev/null\n+++ b\n",
+		},
+		{
+			name:    "removed empty",
+			newName: "/dev/null",
+			want:    "--- a\n+++ /dev/null\n",
+		},
+		{
+			name:    "added from /dev/null",
+			oldName: "/dev/null",
+			new:     "a\n",
+			want:    "--- /dev/null\n+++ b\n@@ -0,0 +1 @@\n+a\n",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			oldName, newName := cmp.Or(tc.oldName, "a"), cmp.Or(tc.newName, "b")
+			if got := string(diff.Unified(oldName, newName, []byte(tc.old), []byte(tc.new))); got != tc.want {
+				t.Errorf("got: %q, want: %q", got, tc.want)
+			}
+		})
+	}
+}
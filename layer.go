@@ -0,0 +1,113 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2025 Hajime Hoshi
+
+package uwagaki
+
+import (
+	"fmt"
+	"slices"
+)
+
+// ConflictError is an error for multiple ReplaceItems targeting the same file in one layer.
+type ConflictError struct {
+	// Layer is the index of the layer passed to MergeLayers.
+	// Layer is 0 for the items passed to NewEnvironment or Update.
+	Layer int
+
+	// Mod is the module path of the items.
+	// Mod is empty when the items are specified by Pkg.
+	Mod string
+
+	// Pkg is the package path of the items.
+	Pkg string
+
+	// Path is the path of the items.
+	Path string
+
+	// Pattern is the pattern of the items.
+	Pattern string
+
+	// Version is the version constraint of the items.
+	Version string
+}
+
+// Error implements error.
+func (e *ConflictError) Error() string {
+	target := e.Mod
+	if e.Pkg != "" {
+		target = e.Pkg
+	}
+	p := e.Path
+	if e.Pattern != "" {
+		p = e.Pattern
+	}
+	return fmt.Sprintf("uwagaki: conflicting ReplaceItems in layer %d: %s: %s", e.Layer, target, p)
+}
+
+// MergeLayers merges layers of ReplaceItems into one list.
+//
+// An item in a later layer overrides an item in an earlier layer targeting the same file.
+// For example, layers can be a team-wide layer, a per-project layer, and a per-test layer in this order.
+// Multiple items targeting the same file in one layer are reported as a *ConflictError.
+//
+// Items are identified by Mod or Pkg, Path or Pattern, and Version.
+// Items specified by Pkg are not resolved to modules, so an item specified by Pkg doesn't override an item specified by Mod.
+// Such conflicts are reported when the merged items are passed to NewEnvironment or Update.
+//
+// The order of the items is kept except for the overridden items.
+func MergeLayers(layers ...[]ReplaceItem) ([]ReplaceItem, error) {
+	var merged []ReplaceItem
+	indices := map[replaceItemKey]int{}
+	for i, layer := range layers {
+		if err := checkConflicts(i, layer); err != nil {
+			return nil, err
+		}
+		for _, r := range layer {
+			k := replaceItemKey{mod: r.Mod, pkg: r.Pkg, path: r.Path, pattern: r.Pattern, version: r.Version}
+			if idx, ok := indices[k]; ok {
+				merged[idx] = r
+				continue
+			}
+			indices[k] = len(merged)
+			merged = append(merged, r)
+		}
+	}
+	return merged, nil
+}
+
+type replaceItemKey struct {
+	mod     string
+	pkg     string
+	path    string
+	pattern string
+	version string
+}
+
+// checkConflicts returns a *ConflictError if multiple items in the layer target the same file.
+func checkConflicts(layer int, items []ReplaceItem) error {
+	seen := map[replaceItemKey]struct{}{}
+	for _, r := range items {
+		k := replaceItemKey{mod: r.Mod, pkg: r.Pkg, path: r.Path, pattern: r.Pattern, version: r.Version}
+		if _, ok := seen[k]; ok {
+			return &ConflictError{
+				Layer:   layer,
+				Mod:     r.Mod,
+				Pkg:     r.Pkg,
+				Path:    r.Path,
+				Pattern: r.Pattern,
+				Version: r.Version,
+			}
+		}
+		seen[k] = struct{}{}
+	}
+	return nil
+}
+
+// withoutVersions returns a copy of items without version constraints.
+func withoutVersions(items []ReplaceItem) []ReplaceItem {
+	items = slices.Clone(items)
+	for i := range items {
+		items[i].Version = ""
+	}
+	return items
+}
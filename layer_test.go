@@ -0,0 +1,119 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2025 Hajime Hoshi
+
+package uwagaki_test
+
+import (
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/hajimehoshi/uwagaki"
+)
+
+func TestMergeLayers(t *testing.T) {
+	item := func(mod, path, content string) uwagaki.ReplaceItem {
+		return uwagaki.ReplaceItem{Mod: mod, Path: path, Content: []byte(content)}
+	}
+
+	testCases := []struct {
+		name   string
+		layers [][]uwagaki.ReplaceItem
+		want   []uwagaki.ReplaceItem
+		layer  int // The index of the conflicting layer, or -1 for no conflicts.
+	}{
+		{
+			name: "override",
+			layers: [][]uwagaki.ReplaceItem{
+				{item("example.com/a", "a.go", "team"), item("example.com/a", "b.go", "team")},
+				{item("example.com/a", "b.go", "project")},
+				{item("example.com/a", "a.go", "test"), item("example.com/b", "a.go", "test")},
+			},
+			want: []uwagaki.ReplaceItem{
+				item("example.com/a", "a.go", "test"),
+				item("example.com/a", "b.go", "project"),
+				item("example.com/b", "a.go", "test"),
+			},
+			layer: -1,
+		},
+		{
+			name: "empty layers",
+			layers: [][]uwagaki.ReplaceItem{
+				nil,
+				{item("example.com/a", "a.go", "project")},
+				nil,
+			},
+			want: []uwagaki.ReplaceItem{
+				item("example.com/a", "a.go", "project"),
+			},
+			layer: -1,
+		},
+		{
+			name: "conflict",
+			layers: [][]uwagaki.ReplaceItem{
+				{item("example.com/a", "a.go", "team")},
+				{item("example.com/a", "a.go", "project"), item("example.com/a", "a.go", "project2")},
+			},
+			layer: 1,
+		},
+		{
+			name: "conflict with the same content",
+			layers: [][]uwagaki.ReplaceItem{
+				{item("example.com/a", "a.go", "team"), item("example.com/a", "a.go", "team")},
+			},
+			layer: 0,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := uwagaki.MergeLayers(tc.layers...)
+			if tc.layer >= 0 {
+				var conflictErr *uwagaki.ConflictError
+				if !errors.As(err, &conflictErr) {
+					t.Fatalf("MergeLayers: got: %v, want: *ConflictError", err)
+				}
+				if conflictErr.Layer != tc.layer {
+					t.Errorf("Layer: got: %d, want: %d", conflictErr.Layer, tc.layer)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.EqualFunc(got, tc.want, func(a, b uwagaki.ReplaceItem) bool {
+				return a.Mod == b.Mod && a.Path == b.Path && string(a.Content) == string(b.Content)
+			}) {
+				t.Errorf("got: %v, want: %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestUpdateConflict(t *testing.T) {
+	dir := createSyncModule(t, "example.com/conflict")
+	t.Chdir(dir)
+
+	items := []uwagaki.ReplaceItem{
+		{Mod: "golang.org/x/sync", Path: "errgroup/foo.go", Content: []byte("package errgroup\n")},
+		{Mod: "golang.org/x/sync", Path: "errgroup/foo.go", Content: []byte("package errgroup\n")},
+	}
+	var conflictErr *uwagaki.ConflictError
+	if _, err := uwagaki.NewEnvironment([]string{"."}, items, nil); !errors.As(err, &conflictErr) {
+		t.Errorf("NewEnvironment: got: %v, want: *ConflictError", err)
+	}
+
+	env, err := uwagaki.NewEnvironment([]string{"."}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer env.Close()
+
+	// The items target the same file after resolving the package.
+	items = []uwagaki.ReplaceItem{
+		{Mod: "golang.org/x/sync", Path: "errgroup/foo.go", Content: []byte("package errgroup\n")},
+		{Pkg: "golang.org/x/sync/errgroup", Path: "foo.go", Content: []byte("package errgroup\n")},
+	}
+	if err := env.Update(items); !errors.As(err, &conflictErr) {
+		t.Errorf("Update: got: %v, want: *ConflictError", err)
+	}
+}
@@ -0,0 +1,310 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2025 Hajime Hoshi
+
+package uwagaki
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"golang.org/x/mod/module"
+)
+
+// Manifest represents a declarative description of an environment.
+//
+// A manifest is a JSON document like this:
+//
+//	{
+//	  "packages": ["./cmd/foo"],
+//	  "require": {"golang.org/x/sync": "v0.11.0"},
+//	  "tools": ["golang.org/x/tools/cmd/stringer"],
+//	  "patchDirs": ["uwagaki"],
+//	  "replaces": [
+//	    {"mod": "golang.org/x/sync", "path": "errgroup/foo.go", "content": "package errgroup\n"},
+//	    {"mod": "golang.org/x/sync", "path": "errgroup/errgroup.go", "diff": "patches/errgroup.patch"},
+//	    {"pkg": "golang.org/x/sync/semaphore", "path": "semaphore.go", "file": "patches/semaphore.go"},
+//	    {"mod": "golang.org/x/sync", "pattern": "**/*_test.go", "delete": true}
+//	  ],
+//	  "options": {"layout": "workspace"}
+//	}
+//
+// Files in a manifest are relative to the directory of the manifest.
+// Packages are interpreted in the current directory like go commands.
+type Manifest struct {
+	// Packages is a list of paths passed to NewEnvironment.
+	Packages []string `json:"packages,omitempty"`
+
+	// Require is a map from module paths to versions required in the environment. See Options.Require.
+	Require map[string]string `json:"require,omitempty"`
+
+	// Tools is a list of package paths of tools added to the environment. See Options.Tools.
+	Tools []string `json:"tools,omitempty"`
+
+	// PatchDirs is a list of directories loaded by LoadPatchDir.
+	PatchDirs []string `json:"patchDirs,omitempty"`
+
+	// Replaces is a list of replacements.
+	Replaces []ManifestReplace `json:"replaces,omitempty"`
+
+	// Options is options of the environment.
+	Options ManifestOptions `json:"options,omitzero"`
+
+	// dir is the base directory for relative file paths.
+	dir string
+}
+
+// ManifestReplace represents a replacement in a manifest.
+//
+// Exactly one of Content, File, Diff, and Delete must be specified.
+// Pattern is available only with Delete.
+// See ReplaceItem for the other fields.
+type ManifestReplace struct {
+	Mod     string `json:"mod,omitempty"`
+	Pkg     string `json:"pkg,omitempty"`
+	Path    string `json:"path,omitempty"`
+	Pattern string `json:"pattern,omitempty"`
+
+	// Content is an inline content of the file.
+	Content *string `json:"content,omitempty"`
+
+	// File is a path to a file with the content.
+	File string `json:"file,omitempty"`
+
+	// Diff is a path to a unified diff to apply to the file.
+	Diff string `json:"diff,omitempty"`
+
+	// Delete indicates that the file is deleted.
+	Delete bool `json:"delete,omitempty"`
+
+	Version  string `json:"version,omitempty"`
+	BaseHash string `json:"baseHash,omitempty"`
+}
+
+// ManifestOptions represents options in a manifest. See Options for details.
+type ManifestOptions struct {
+	ModuleName string `json:"moduleName,omitempty"`
+
+	// Layout is "module", "workspace", or "modfile". The default is "module".
+	Layout string `json:"layout,omitempty"`
+
+	// Modules is a list of module directories relative to the manifest.
+	Modules []string `json:"modules,omitempty"`
+}
+
+var manifestLayouts = map[string]Layout{
+	"":          LayoutModule,
+	"module":    LayoutModule,
+	"workspace": LayoutWorkspace,
+	"modfile":   LayoutModFile,
+}
+
+// LoadManifest loads and validates the manifest file.
+func LoadManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseManifest(data, filepath.Dir(path))
+}
+
+// ParseManifest parses and validates the manifest.
+// dir is the base directory for relative file paths in the manifest.
+//
+// ParseManifest doesn't read files referred by the manifest.
+func ParseManifest(data []byte, dir string) (*Manifest, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var m Manifest
+	if err := dec.Decode(&m); err != nil {
+		return nil, fmt.Errorf("uwagaki: invalid manifest: %w", err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, fmt.Errorf("uwagaki: invalid manifest: unexpected data after the manifest")
+	}
+
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	m.dir = dir
+
+	if _, ok := manifestLayouts[m.Options.Layout]; !ok {
+		return nil, fmt.Errorf("uwagaki: invalid manifest: invalid layout: %q", m.Options.Layout)
+	}
+	for path, version := range m.Require {
+		if err := module.Check(path, version); err != nil {
+			return nil, fmt.Errorf("uwagaki: invalid manifest: require: %w", err)
+		}
+	}
+	for _, tool := range m.Tools {
+		if err := checkTool(tool); err != nil {
+			return nil, fmt.Errorf("uwagaki: invalid manifest: tools: %w", err)
+		}
+	}
+	for i, r := range m.Replaces {
+		if err := r.validate(); err != nil {
+			return nil, fmt.Errorf("uwagaki: invalid manifest: replaces[%d]: %w", i, err)
+		}
+	}
+	return &m, nil
+}
+
+func (r *ManifestReplace) validate() error {
+	var sources []string
+	if r.Content != nil {
+		sources = append(sources, "content")
+	}
+	if r.File != "" {
+		sources = append(sources, "file")
+	}
+	if r.Diff != "" {
+		sources = append(sources, "diff")
+	}
+	if r.Delete {
+		sources = append(sources, "delete")
+	}
+	if len(sources) != 1 {
+		return fmt.Errorf("exactly one of content, file, diff, and delete must be specified: %s", strings.Join(sources, ", "))
+	}
+	if r.Pattern != "" && !r.Delete {
+		return fmt.Errorf("pattern is available only with delete")
+	}
+
+	// Validate the item without reading files. Module paths are validated strictly when the environment is created.
+	item := r.item(nil)
+	if r.Diff != "" {
+		item.Transform = func(string, []byte) ([]byte, error) { return nil, nil }
+	}
+	return validateReplaceItem(&item, func(string) bool { return true })
+}
+
+// item returns a ReplaceItem for the replacement with the content.
+func (r *ManifestReplace) item(content []byte) ReplaceItem {
+	return ReplaceItem{
+		Mod:      r.Mod,
+		Pkg:      r.Pkg,
+		Path:     r.Path,
+		Pattern:  r.Pattern,
+		Content:  content,
+		Delete:   r.Delete,
+		Version:  r.Version,
+		BaseHash: r.BaseHash,
+	}
+}
+
+// path returns the absolute path of a file in the manifest.
+func (m *Manifest) path(path string) string {
+	path = filepath.FromSlash(path)
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(m.dir, path)
+}
+
+// ReplaceItems returns ReplaceItems described by the manifest.
+//
+// The patch directories and the replacements are merged by MergeLayers in this order,
+// so the replacements override the patch directories.
+func (m *Manifest) ReplaceItems() ([]ReplaceItem, error) {
+	var layers [][]ReplaceItem
+	for _, dir := range m.PatchDirs {
+		items, err := LoadPatchDir(m.path(dir))
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, items)
+	}
+
+	var items []ReplaceItem
+	for _, r := range m.Replaces {
+		switch {
+		case r.Content != nil:
+			items = append(items, r.item([]byte(*r.Content)))
+		case r.File != "":
+			content, err := os.ReadFile(m.path(r.File))
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, r.item(content))
+		case r.Diff != "":
+			patchPath := m.path(r.Diff)
+			patch, err := os.ReadFile(patchPath)
+			if err != nil {
+				return nil, err
+			}
+			item := r.item(nil)
+			item.Transform = patchTransform(patchPath, patch)
+			items = append(items, item)
+		default:
+			items = append(items, r.item(nil))
+		}
+	}
+	layers = append(layers, items)
+
+	return MergeLayers(layers...)
+}
+
+// EnvironmentOptions returns Options described by the manifest.
+func (m *Manifest) EnvironmentOptions() *Options {
+	options := &Options{
+		ModuleName: m.Options.ModuleName,
+		Layout:     manifestLayouts[m.Options.Layout],
+		Tools:      slices.Clone(m.Tools),
+	}
+	for _, dir := range m.Options.Modules {
+		options.Modules = append(options.Modules, m.path(dir))
+	}
+	for _, path := range slices.Sorted(maps.Keys(m.Require)) {
+		options.Require = append(options.Require, module.Version{
+			Path:    path,
+			Version: m.Require[path],
+		})
+	}
+	return options
+}
+
+// NewEnvironment creates a new environment described by the manifest.
+//
+// options overrides Options described by the manifest except for the zero values.
+// options can be nil.
+func (m *Manifest) NewEnvironment(options *Options) (*Environment, error) {
+	items, err := m.ReplaceItems()
+	if err != nil {
+		return nil, err
+	}
+	o := m.EnvironmentOptions()
+	if options != nil {
+		if options.ModuleName != "" {
+			o.ModuleName = options.ModuleName
+		}
+		if options.Dir != "" {
+			o.Dir = options.Dir
+		}
+		if options.Layout != LayoutModule {
+			o.Layout = options.Layout
+		}
+		if len(options.Modules) > 0 {
+			o.Modules = options.Modules
+		}
+		if len(options.Require) > 0 {
+			o.Require = append(o.Require, options.Require...)
+		}
+		if len(options.Tools) > 0 {
+			o.Tools = append(o.Tools, options.Tools...)
+		}
+		if options.OnDrift != nil {
+			o.OnDrift = options.OnDrift
+		}
+		if options.Detached {
+			o.Detached = true
+		}
+	}
+	return NewEnvironment(m.Packages, items, o)
+}
@@ -0,0 +1,117 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2025 Hajime Hoshi
+
+package uwagaki_test
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hajimehoshi/uwagaki"
+)
+
+func TestManifest(t *testing.T) {
+	dir := createSyncModule(t, "example.com/manifest")
+	patch := mustReadFile("./testdata/sync/additional_file_by_uwagaki.go")
+
+	// The original module requires v0.11.0. LayoutModFile is used as the version can be downgraded only when the original go.mod is the main module.
+	manifestDir := t.TempDir()
+	writeFiles(t, manifestDir, map[string]string{
+		"uwagaki.json": `{
+  "packages": ["."],
+  "require": {"golang.org/x/sync": "v0.10.0"},
+  "patchDirs": ["patches"],
+  "replaces": [
+    {"mod": "golang.org/x/sync", "path": "additional_file_by_uwagaki.go", "file": "files/additional_file_by_uwagaki.go", "version": "v0.10.0"},
+    {"mod": "golang.org/x/sync", "path": "semaphore/inline.go", "content": "package semaphore\n"},
+    {"mod": "golang.org/x/sync", "path": "errgroup/errgroup.go", "diff": "errgroup.patch"},
+    {"mod": "golang.org/x/sync", "pattern": "**/*_test.go", "delete": true}
+  ],
+  "options": {"layout": "modfile"}
+}
+`,
+		"files/additional_file_by_uwagaki.go": string(patch),
+		"errgroup.patch": `@@ -119,3 +119,3 @@
+ // SetLimit limits the number of active goroutines in this group to at most n.
+-// A negative value indicates no limit.
++// A negative value indicates no limit (patched).
+ //
+`,
+		// This is overridden by the inline replacement.
+		"patches/golang.org/x/sync@/semaphore/inline.go":     "package semaphore // overridden\n",
+		"patches/golang.org/x/sync@/singleflight/patched.go": "package singleflight\n",
+	})
+
+	manifest, err := uwagaki.LoadManifest(filepath.Join(manifestDir, "uwagaki.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Chdir(dir)
+	env, err := manifest.NewEnvironment(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer env.Close()
+
+	if got, want := env.PathModules()[0].Path, "example.com/manifest"; got != want {
+		t.Errorf("PathModules()[0].Path: got: %s, want: %s", got, want)
+	}
+
+	cmd := exec.Command("go", "run")
+	cmd.Args = append(cmd.Args, env.Paths()...)
+	cmd.Dir = env.WorkingDir()
+	cmd.Env = append(os.Environ(), env.Env()...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	if got, want := strings.TrimSpace(string(out)), "Hello, Uwagaki (sync)!"; got != want {
+		t.Errorf("output: got: %q, want: %q", got, want)
+	}
+
+	var buf bytes.Buffer
+	if err := env.Diff(&buf); err != nil {
+		t.Fatal(err)
+	}
+	got := buf.String()
+	for _, want := range []string{
+		"+++ b/golang.org/x/sync@v0.10.0/additional_file_by_uwagaki.go\n",
+		"+++ b/golang.org/x/sync@v0.10.0/semaphore/inline.go\n@@ -0,0 +1 @@\n+package semaphore\n",
+		"+++ b/golang.org/x/sync@v0.10.0/singleflight/patched.go\n",
+		"-// A negative value indicates no limit.\n+// A negative value indicates no limit (patched).\n",
+		"--- a/golang.org/x/sync@v0.10.0/errgroup/errgroup_test.go\n+++ /dev/null\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("the diff must include %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestParseManifestError(t *testing.T) {
+	for _, manifest := range []string{
+		`{"unknown": 1}`,
+		`{"packages": ["."]} {}`,
+		`{"options": {"layout": "unknown"}}`,
+		`{"require": {"golang.org/x/sync": "latest"}}`,
+		`{"tools": ["../cmd/foo"]}`,
+		`{"tools": ["golang.org/x/tools/cmd/stringer@"]}`,
+		`{"replaces": [{"mod": "golang.org/x/sync", "path": "foo.go"}]}`,
+		`{"replaces": [{"mod": "golang.org/x/sync", "path": "foo.go", "content": "", "delete": true}]}`,
+		`{"replaces": [{"mod": "golang.org/x/sync", "pattern": "*.go", "file": "foo.go"}]}`,
+		`{"replaces": [{"mod": "golang.org/x/sync", "path": "../foo.go", "content": ""}]}`,
+		`{"replaces": [{"mod": "golang.org/x/sync", "path": "foo.go", "content": "", "version": "latest"}]}`,
+	} {
+		if _, err := uwagaki.ParseManifest([]byte(manifest), "."); err == nil {
+			t.Errorf("ParseManifest(%q) must fail", manifest)
+		}
+	}
+
+	if _, err := uwagaki.ParseManifest([]byte(`{"replaces": [{"mod": "golang.org/x/sync", "path": "foo.go", "content": ""}]}`), "."); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,271 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2025 Hajime Hoshi
+
+package uwagaki
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/mod/module"
+)
+
+// metadataFile is the name of the file to store the environment's metadata in the environment directory.
+// The name is distinct from a manifest's usual name so that a directory with a manifest is not regarded as an environment.
+const metadataFile = ".uwagaki-env.json"
+
+// metadataFormat is the format version of the metadata.
+// Metadata without this format version is not regarded as an environment's.
+const metadataFormat = 1
+
+// environmentMetadata represents the metadata of an environment to open it later.
+type environmentMetadata struct {
+	// Format is the format version of the metadata. Format must be metadataFormat.
+	Format int
+
+	// PID is the process ID of the creator. PID is 0 for a detached environment.
+	PID int `json:",omitempty"`
+
+	// Hostname is the host name of the creator.
+	Hostname string `json:",omitempty"`
+
+	// Created is the time when the environment was created.
+	Created time.Time
+
+	// CurrentDir is the current directory when the environment was created.
+	CurrentDir string
+
+	Layout Layout
+
+	// WorkingDir is the working directory for LayoutModFile.
+	// WorkingDir is empty for the other layouts, as the working directory is the environment directory.
+	WorkingDir string `json:",omitempty"`
+
+	Paths       []string
+	OrigPaths   []string
+	PathModules []module.Version
+
+	MainModules []metadataMainModule
+	Modules     []metadataModule
+	Replaced    []metadataReplacedFile
+}
+
+type metadataMainModule struct {
+	Path string
+	Dir  string
+}
+
+type metadataModule struct {
+	Path    string
+	Version string `json:",omitempty"`
+	OrigDir string
+
+	// Dir is a slash-separated directory path relative to the environment directory.
+	Dir string
+}
+
+type metadataReplacedFile struct {
+	Mod  string
+	Path string
+}
+
+// writeMetadata writes the metadata to the environment directory.
+func (e *Environment) writeMetadata() error {
+	md := environmentMetadata{
+		Format:      metadataFormat,
+		PID:         e.pid,
+		Hostname:    e.hostname,
+		Created:     e.created,
+		CurrentDir:  e.currentDir,
+		Layout:      e.layout,
+		Paths:       e.paths,
+		OrigPaths:   e.origPaths,
+		PathModules: e.pathModules,
+	}
+	if e.layout == LayoutModFile {
+		md.WorkingDir = e.workingDir
+	}
+	for _, mm := range e.mainModules {
+		md.MainModules = append(md.MainModules, metadataMainModule{
+			Path: mm.path,
+			Dir:  mm.dir,
+		})
+	}
+	for _, mod := range slices.Sorted(maps.Keys(e.modules)) {
+		m := e.modules[mod]
+		dir, err := filepath.Rel(e.dir, m.dir)
+		if err != nil {
+			return err
+		}
+		md.Modules = append(md.Modules, metadataModule{
+			Path:    mod,
+			Version: m.version,
+			OrigDir: m.origDir,
+			Dir:     filepath.ToSlash(dir),
+		})
+	}
+	for f := range e.replaced {
+		md.Replaced = append(md.Replaced, metadataReplacedFile{
+			Mod:  f.mod,
+			Path: f.path,
+		})
+	}
+	slices.SortFunc(md.Replaced, func(a, b metadataReplacedFile) int {
+		return cmp.Or(strings.Compare(a.Mod, b.Mod), strings.Compare(a.Path, b.Path))
+	})
+
+	content, err := json.MarshalIndent(md, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(e.dir, metadataFile), append(content, '\n'), 0644)
+}
+
+// readMetadata reads the metadata in the environment directory.
+func readMetadata(dir string) (*environmentMetadata, error) {
+	content, err := os.ReadFile(filepath.Join(dir, metadataFile))
+	if err != nil {
+		return nil, fmt.Errorf("uwagaki: %s is not an environment: %w", dir, err)
+	}
+	// Reject unknown fields strictly so that a foreign JSON file is not regarded as metadata.
+	dec := json.NewDecoder(bytes.NewReader(content))
+	dec.DisallowUnknownFields()
+	var md environmentMetadata
+	if err := dec.Decode(&md); err != nil {
+		return nil, fmt.Errorf("uwagaki: invalid metadata in %s: %w", dir, err)
+	}
+	if md.Format != metadataFormat {
+		return nil, fmt.Errorf("uwagaki: invalid metadata in %s: unsupported format %d", dir, md.Format)
+	}
+	if md.Created.IsZero() {
+		return nil, fmt.Errorf("uwagaki: invalid metadata in %s: no creation time", dir)
+	}
+	return &md, nil
+}
+
+// OpenEnvironment opens an environment created by NewEnvironment or CreateEnvironment in the directory.
+//
+// This is useful to continue to use an environment in another process,
+// e.g., to extract ReplaceItems by Environment.ReplaceItems after editing files in the environment directly.
+//
+// Options given at creating the environment like Options.OnDrift are not restored.
+func OpenEnvironment(dir string) (*Environment, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	md, err := readMetadata(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Environment{
+		dir:         dir,
+		workingDir:  dir,
+		paths:       md.Paths,
+		origPaths:   md.OrigPaths,
+		layout:      md.Layout,
+		pathModules: md.PathModules,
+		modules:     map[string]*envModule{},
+		replaced:    map[replacedFile]struct{}{},
+		created:     md.Created,
+		currentDir:  md.CurrentDir,
+		pid:         md.PID,
+		hostname:    md.Hostname,
+	}
+	if md.Layout == LayoutModFile {
+		e.workingDir = md.WorkingDir
+	}
+	for _, mm := range md.MainModules {
+		e.mainModules = append(e.mainModules, mainModule{
+			path: mm.Path,
+			dir:  mm.Dir,
+		})
+	}
+	for _, m := range md.Modules {
+		e.modules[m.Path] = &envModule{
+			version: m.Version,
+			origDir: m.OrigDir,
+			dir:     filepath.Join(dir, filepath.FromSlash(m.Dir)),
+		}
+	}
+	for _, f := range md.Replaced {
+		if _, ok := e.modules[f.Mod]; !ok {
+			return nil, fmt.Errorf("uwagaki: invalid metadata in %s: unknown module %s", dir, f.Mod)
+		}
+		e.replaced[replacedFile{mod: f.Mod, path: f.Path}] = struct{}{}
+	}
+
+	items, err := e.ReplaceItems()
+	if err != nil {
+		return nil, err
+	}
+	e.replaces = items
+	return e, nil
+}
+
+// RemoveEnvironment removes an environment created by NewEnvironment or CreateEnvironment in the directory.
+//
+// RemoveEnvironment fails without removing anything if the directory doesn't have valid metadata of an environment.
+// Unlike OpenEnvironment, RemoveEnvironment doesn't read the original modules,
+// so an environment can be removed even after its original modules are removed.
+func RemoveEnvironment(dir string) error {
+	if _, err := readMetadata(dir); err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+// ReplaceItems returns ReplaceItems equivalent to the current files of the modules copied to the environment.
+//
+// ReplaceItems compares the whole trees of the copied modules with the original modules,
+// so files edited in the environment directly are also reported.
+// An added or modified file is reported as an item with Content, and a deleted file is reported as an item with Delete.
+// BaseHash of an item is the hash of the original file if exists.
+//
+// The items are sorted by their module paths and their paths.
+func (e *Environment) ReplaceItems() ([]ReplaceItem, error) {
+	var items []ReplaceItem
+	for _, mod := range slices.Sorted(maps.Keys(e.modules)) {
+		m := e.modules[mod]
+		names, err := moduleFileUnion(m)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			orig, origExists, err := readModuleFile(m.origDir, name)
+			if err != nil {
+				return nil, err
+			}
+			current, currentExists, err := readModuleFile(m.dir, name)
+			if err != nil {
+				return nil, err
+			}
+			if origExists == currentExists && bytes.Equal(orig, current) {
+				continue
+			}
+			r := ReplaceItem{
+				Mod:  mod,
+				Path: name,
+			}
+			if origExists {
+				r.BaseHash = Hash(orig)
+			}
+			if currentExists {
+				r.Content = current
+			} else {
+				r.Delete = true
+			}
+			items = append(items, r)
+		}
+	}
+	return items, nil
+}
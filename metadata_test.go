@@ -0,0 +1,170 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2025 Hajime Hoshi
+
+package uwagaki_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/hajimehoshi/uwagaki"
+)
+
+func TestOpenEnvironment(t *testing.T) {
+	dir := createSyncModule(t, "example.com/open")
+	patch := mustReadFile("./testdata/sync/additional_file_by_uwagaki.go")
+	t.Chdir(dir)
+
+	env, err := uwagaki.NewEnvironment([]string{"."}, []uwagaki.ReplaceItem{
+		{
+			Mod:     "golang.org/x/sync",
+			Path:    "additional_file_by_uwagaki.go",
+			Content: patch,
+		},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer env.Close()
+
+	// Edit the files in the environment directly.
+	modDir := filepath.Join(env.Dir(), "mod", "golang.org", "x", "sync+v0.11.0")
+	if err := os.Remove(filepath.Join(modDir, "errgroup", "go120_test.go")); err != nil {
+		t.Fatal(err)
+	}
+	errgroupPath := filepath.Join(modDir, "errgroup", "errgroup.go")
+	orig, err := os.ReadFile(errgroupPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edited := append(slices.Clone(orig), "// Edited.\n"...)
+	if err := os.WriteFile(errgroupPath, edited, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := uwagaki.OpenEnvironment(dir); err == nil {
+		t.Errorf("OpenEnvironment with a non-environment directory must fail")
+	}
+
+	// A JSON file that is not metadata is rejected.
+	for _, content := range []string{
+		`{"packages": ["."]}`,
+		`{"Created": "2025-01-01T00:00:00Z"}`,
+		`{"Format": 1, "packages": ["."]}`,
+	} {
+		foreign := t.TempDir()
+		if err := os.WriteFile(filepath.Join(foreign, ".uwagaki-env.json"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := uwagaki.OpenEnvironment(foreign); err == nil {
+			t.Errorf("OpenEnvironment with metadata %s must fail", content)
+		}
+	}
+
+	opened, err := uwagaki.OpenEnvironment(env.Dir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := opened.Paths(), env.Paths(); !slices.Equal(got, want) {
+		t.Errorf("Paths: got: %v, want: %v", got, want)
+	}
+
+	items, err := opened.ReplaceItems()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []uwagaki.ReplaceItem{
+		{Mod: "golang.org/x/sync", Path: "additional_file_by_uwagaki.go", Content: patch},
+		{Mod: "golang.org/x/sync", Path: "errgroup/errgroup.go", Content: edited, BaseHash: uwagaki.Hash(orig)},
+		{Mod: "golang.org/x/sync", Path: "errgroup/go120_test.go", Delete: true},
+	}
+	if !slices.EqualFunc(items, want, func(a, b uwagaki.ReplaceItem) bool {
+		return a.Mod == b.Mod && a.Path == b.Path && bytes.Equal(a.Content, b.Content) && a.Delete == b.Delete && (b.BaseHash == "" || a.BaseHash == b.BaseHash)
+	}) {
+		t.Errorf("ReplaceItems: got: %v, want: %v", items, want)
+	}
+
+	// The extracted items reproduce the same environment.
+	env2, err := uwagaki.NewEnvironment([]string{"."}, items, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer env2.Close()
+	var diff1, diff2 bytes.Buffer
+	if err := env.Diff(&diff1); err != nil {
+		t.Fatal(err)
+	}
+	if err := env2.Diff(&diff2); err != nil {
+		t.Fatal(err)
+	}
+	if diff1.String() != diff2.String() {
+		t.Errorf("Diff: got: %s, want: %s", diff2.String(), diff1.String())
+	}
+
+	// The opened environment can be updated. The replaced file is restored, while the edited files are kept.
+	if err := opened.Update(nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(modDir, "additional_file_by_uwagaki.go")); !os.IsNotExist(err) {
+		t.Errorf("the replaced file must be removed: %v", err)
+	}
+}
+
+func TestRemoveEnvironment(t *testing.T) {
+	dir := createSyncModule(t, "example.com/remove")
+	patch := mustReadFile("./testdata/sync/additional_file_by_uwagaki.go")
+	t.Chdir(dir)
+
+	env, err := uwagaki.NewEnvironment([]string{"."}, []uwagaki.ReplaceItem{
+		{
+			Mod:     "golang.org/x/sync",
+			Path:    "additional_file_by_uwagaki.go",
+			Content: patch,
+		},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer env.Close()
+
+	// A directory with a manifest is not removed.
+	if err := os.WriteFile(filepath.Join(dir, "uwagaki.json"), []byte(`{"packages": ["."]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := uwagaki.RemoveEnvironment(dir); err == nil {
+		t.Errorf("RemoveEnvironment with a non-environment directory must fail")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "go.mod")); err != nil {
+		t.Errorf("the non-environment directory must not be removed: %v", err)
+	}
+
+	// Make the original module unavailable. OpenEnvironment fails, but RemoveEnvironment succeeds.
+	mdPath := filepath.Join(env.Dir(), ".uwagaki-env.json")
+	var md map[string]any
+	if err := json.Unmarshal(mustReadFile(mdPath), &md); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range md["Modules"].([]any) {
+		m.(map[string]any)["OrigDir"] = filepath.Join(t.TempDir(), "missing")
+	}
+	content, err := json.Marshal(md)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(mdPath, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := uwagaki.OpenEnvironment(env.Dir()); err == nil {
+		t.Errorf("OpenEnvironment without the original module must fail")
+	}
+	if err := uwagaki.RemoveEnvironment(env.Dir()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(env.Dir()); !os.IsNotExist(err) {
+		t.Errorf("the environment must be removed: %v", err)
+	}
+}
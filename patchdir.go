@@ -0,0 +1,249 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2025 Hajime Hoshi
+
+package uwagaki
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+
+	"github.com/hajimehoshi/uwagaki/internal/diff"
+)
+
+const (
+	// deleteMarkerSuffix is the suffix of a marker file to delete a file.
+	deleteMarkerSuffix = ".uwagaki-delete"
+
+	// patchMarkerSuffix is the suffix of a marker file including a unified diff to apply to a file.
+	patchMarkerSuffix = ".uwagaki-patch"
+)
+
+// PatchDir represents a directory whose files replace files in a module.
+// The directory tree mirrors the module's tree.
+// For example, a file foo/bar.go in the directory replaces foo/bar.go in the module.
+//
+// Marker files are also available. See LoadPatchDir.
+type PatchDir struct {
+	// Mod is a module path.
+	// If Mod is empty, Dir is a directory including multiple modules, and is loaded by LoadPatchDir.
+	Mod string
+
+	// Dir is a directory path.
+	Dir string
+}
+
+// ReplaceItems returns a list of ReplaceItem for all the files in the directory.
+func (p *PatchDir) ReplaceItems() ([]ReplaceItem, error) {
+	if p.Mod == "" {
+		return LoadPatchDir(p.Dir)
+	}
+	return loadModulePatchDir(p.Dir, p.Mod, "")
+}
+
+// LoadPatchDir loads ReplaceItems from a directory including patches for multiple modules.
+//
+// The directory is structured as '<module path>@<version>/<file path>'.
+// A directory whose name includes '@' is the root directory of a module.
+// Upper-case letters in the module path are escaped in the same way as the module cache, like '!burnt!sushi' for 'BurntSushi',
+// so that module paths never collide on case-insensitive file systems.
+// As the module root is marked, a major version suffix like '/v2' is part of the module path, not a directory in the module.
+// The version after '@' is optional. If the version is specified, the items are applied only to the version (see ReplaceItem.Version).
+// For example, 'golang.org/x/sync@/errgroup/errgroup.go' replaces errgroup/errgroup.go in golang.org/x/sync of any version,
+// and 'github.com/!burnt!sushi/toml@v1.4.0/decode.go' replaces decode.go in github.com/BurntSushi/toml v1.4.0.
+//
+// A file is a replacement of the file at the same path in the module, except for marker files:
+//
+//   - A file with the suffix '.uwagaki-delete' deletes the file without the suffix. The content is ignored.
+//   - A file with the suffix '.uwagaki-patch' is a unified diff to apply to the file without the suffix.
+//
+// A file outside module directories is an error.
+func LoadPatchDir(dir string) ([]ReplaceItem, error) {
+	var items []ReplaceItem
+	if err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == dir {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if !d.IsDir() {
+			return fmt.Errorf("uwagaki: %s is not in a module directory; the name of a module directory must include '@'", rel)
+		}
+
+		escaped, version, ok := strings.Cut(rel, "@")
+		if !ok {
+			return nil
+		}
+		mod, err := module.UnescapePath(escaped)
+		if err != nil {
+			return fmt.Errorf("uwagaki: invalid module directory %s: %w", rel, err)
+		}
+		if version != "" && !semver.IsValid(version) {
+			return fmt.Errorf("uwagaki: invalid version in %s", rel)
+		}
+		is, err := loadModulePatchDir(path, mod, version)
+		if err != nil {
+			return err
+		}
+		items = append(items, is...)
+		return filepath.SkipDir
+	}); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// loadModulePatchDir loads ReplaceItems for the module from the directory mirroring the module tree.
+func loadModulePatchDir(dir string, mod string, version string) ([]ReplaceItem, error) {
+	var items []ReplaceItem
+	if err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		items = append(items, patchDirItem(path, mod, version, filepath.ToSlash(rel), content))
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// patchDirItem returns a ReplaceItem for the file in a module directory.
+// rel is the slash-separated path of the file relative to the module directory, which might have a marker suffix.
+func patchDirItem(file string, mod string, version string, rel string, content []byte) ReplaceItem {
+	r := ReplaceItem{
+		Mod:     mod,
+		Path:    rel,
+		Version: version,
+	}
+	switch {
+	case strings.HasSuffix(r.Path, deleteMarkerSuffix):
+		r.Path = strings.TrimSuffix(r.Path, deleteMarkerSuffix)
+		r.Delete = true
+	case strings.HasSuffix(r.Path, patchMarkerSuffix):
+		r.Path = strings.TrimSuffix(r.Path, patchMarkerSuffix)
+		r.Transform = patchTransform(file, content)
+	default:
+		r.Content = content
+	}
+	return r
+}
+
+// patchDirModuleDir returns the module directory for the module and the version in a directory in the structure of LoadPatchDir.
+func patchDirModuleDir(dir string, mod string, version string) (string, error) {
+	escaped, err := module.EscapePath(mod)
+	if err != nil {
+		return "", fmt.Errorf("uwagaki: invalid module path %s: %w", mod, err)
+	}
+	return filepath.Join(dir, filepath.FromSlash(escaped)+"@"+version), nil
+}
+
+// LoadPatchDirFile loads the replacement of a file in a module from a directory in the structure of LoadPatchDir.
+//
+// version is the version in the name of the module directory, and is empty for the directory for any versions.
+// LoadPatchDirFile returns the item and the path of the file that the item is loaded from, which might be a marker file.
+// LoadPatchDirFile returns nil and an empty string if the file is not replaced.
+func LoadPatchDirFile(dir string, mod string, version string, path string) (*ReplaceItem, string, error) {
+	modDir, err := patchDirModuleDir(dir, mod, version)
+	if err != nil {
+		return nil, "", err
+	}
+	for _, suffix := range []string{"", patchMarkerSuffix, deleteMarkerSuffix} {
+		file := filepath.Join(modDir, filepath.FromSlash(path)) + suffix
+		content, err := os.ReadFile(file)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		r := patchDirItem(file, mod, version, path+suffix, content)
+		return &r, file, nil
+	}
+	return nil, "", nil
+}
+
+// patchTransform returns a function for ReplaceItem.Transform to apply the unified diff in the file at patchPath.
+func patchTransform(patchPath string, patch []byte) func(path string, content []byte) ([]byte, error) {
+	return func(path string, content []byte) ([]byte, error) {
+		c, err := diff.Apply(content, patch)
+		if err != nil {
+			return nil, fmt.Errorf("uwagaki: failed to apply %s to %s: %w", patchPath, path, err)
+		}
+		return c, nil
+	}
+}
+
+// WritePatchDir writes the ReplaceItems to the directory in the structure of LoadPatchDir.
+//
+// Only items with Mod and Path, and Content or Delete are available. Mod must be a valid module path for module.EscapePath.
+// Version must be empty or an exact version like 'v0.11.0'.
+// BaseHash is not written.
+//
+// A file or a marker file for the same file in the directory is overwritten or removed.
+// The other files in the directory are kept.
+func WritePatchDir(dir string, items []ReplaceItem) error {
+	for _, r := range items {
+		if r.Mod == "" || r.Path == "" || r.Transform != nil || (r.Content == nil && !r.Delete) {
+			return fmt.Errorf("uwagaki: only an item with Mod and Path, and Content or Delete can be written to a patch directory: %s, %s", r.Mod, r.Path)
+		}
+		if err := validateReplaceItem(&r, func(string) bool { return true }); err != nil {
+			return err
+		}
+		var version string
+		if r.Version != "" {
+			c, err := parseVersionConstraint(r.Version)
+			if err != nil {
+				return err
+			}
+			if len(c) != 1 || c[0].op != "=" {
+				return fmt.Errorf("uwagaki: only an exact version can be written to a patch directory: %s", r.Version)
+			}
+			version = c[0].version
+		}
+
+		modDir, err := patchDirModuleDir(dir, r.Mod, version)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(modDir, filepath.FromSlash(r.Path))
+		for _, suffix := range []string{"", deleteMarkerSuffix, patchMarkerSuffix} {
+			if err := os.Remove(dst + suffix); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+		if r.Delete {
+			dst += deleteMarkerSuffix
+		}
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(dst, r.Content, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
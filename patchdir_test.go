@@ -0,0 +1,258 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2025 Hajime Hoshi
+
+package uwagaki_test
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/hajimehoshi/uwagaki"
+)
+
+func TestLoadPatchDir(t *testing.T) {
+	dir := createSyncModule(t, "example.com/patchdir")
+	patch := mustReadFile("./testdata/sync/additional_file_by_uwagaki.go")
+	t.Chdir(dir)
+
+	patchDir := t.TempDir()
+	writeFiles(t, patchDir, map[string]string{
+		"golang.org/x/sync@/additional_file_by_uwagaki.go":         string(patch),
+		"golang.org/x/sync@/errgroup/go120_test.go.uwagaki-delete": "",
+		"golang.org/x/sync@/errgroup/errgroup.go.uwagaki-patch": `--- a/errgroup/errgroup.go
++++ b/errgroup/errgroup.go
+@@ -119,3 +119,3 @@
+ // SetLimit limits the number of active goroutines in this group to at most n.
+-// A negative value indicates no limit.
++// A negative value indicates no limit (patched).
+ // A limit of zero will prevent any new goroutines from being added.
+`,
+		// This is not applied to v0.11.0.
+		"golang.org/x/sync@v0.10.0/additional_file_by_uwagaki.go": "package sync\n\nfunc AdditionalFuncByUwagaki() {}\n",
+	})
+
+	items, err := uwagaki.LoadPatchDir(patchDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(items), 4; got != want {
+		t.Fatalf("len(items): got: %d, want: %d", got, want)
+	}
+	dirItems, err := (&uwagaki.PatchDir{Dir: patchDir}).ReplaceItems()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(dirItems), len(items); got != want {
+		t.Errorf("len(PatchDir.ReplaceItems()): got: %d, want: %d", got, want)
+	}
+
+	env, err := uwagaki.NewEnvironment([]string{"."}, items, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer env.Close()
+
+	cmd := exec.Command("go", "run")
+	cmd.Args = append(cmd.Args, env.Paths()...)
+	cmd.Dir = env.Dir()
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	if got, want := strings.TrimSpace(string(out)), "Hello, Uwagaki (sync)!"; got != want {
+		t.Errorf("output: got: %q, want: %q", got, want)
+	}
+
+	var buf bytes.Buffer
+	if err := env.Diff(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"+++ b/golang.org/x/sync@v0.11.0/additional_file_by_uwagaki.go\n",
+		"--- a/golang.org/x/sync@v0.11.0/errgroup/go120_test.go\n+++ /dev/null\n",
+		"-// A negative value indicates no limit.\n+// A negative value indicates no limit (patched).\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("the diff must include %q:\n%s", want, buf.String())
+		}
+	}
+
+	// Write the items extracted from the environment, and load them again.
+	extracted, err := env.ReplaceItems()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writtenDir := t.TempDir()
+	if err := uwagaki.WritePatchDir(writtenDir, extracted); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := uwagaki.LoadPatchDir(writtenDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.EqualFunc(loaded, extracted, func(a, b uwagaki.ReplaceItem) bool {
+		return a.Mod == b.Mod && a.Path == b.Path && bytes.Equal(a.Content, b.Content) && a.Delete == b.Delete
+	}) {
+		t.Errorf("LoadPatchDir: got: %v, want: %v", loaded, extracted)
+	}
+}
+
+func TestWritePatchDirEscape(t *testing.T) {
+	dir := t.TempDir()
+	items := []uwagaki.ReplaceItem{
+		{Mod: "github.com/BurntSushi/toml", Path: "decode.go", Content: []byte("package toml\n"), Version: "v1.4.0"},
+		{Mod: "github.com/hajimehoshi/uwagaki/v2", Path: "README.md", Delete: true},
+	}
+	if err := uwagaki.WritePatchDir(dir, items); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{
+		"github.com/!burnt!sushi/toml@v1.4.0/decode.go",
+		"github.com/hajimehoshi/uwagaki/v2@/README.md.uwagaki-delete",
+	} {
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name))); err != nil {
+			t.Error(err)
+		}
+	}
+
+	loaded, err := uwagaki.LoadPatchDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.EqualFunc(loaded, items, func(a, b uwagaki.ReplaceItem) bool {
+		return a.Mod == b.Mod && a.Path == b.Path && bytes.Equal(a.Content, b.Content) && a.Delete == b.Delete && a.Version == b.Version
+	}) {
+		t.Errorf("LoadPatchDir: got: %v, want: %v", loaded, items)
+	}
+
+	// Writing a file removes the marker file for the same file.
+	if err := uwagaki.WritePatchDir(dir, []uwagaki.ReplaceItem{
+		{Mod: "github.com/hajimehoshi/uwagaki/v2", Path: "README.md", Content: []byte("# README\n")},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "github.com", "hajimehoshi", "uwagaki", "v2@", "README.md.uwagaki-delete")); !os.IsNotExist(err) {
+		t.Errorf("the marker file must be removed: %v", err)
+	}
+
+	for _, item := range []uwagaki.ReplaceItem{
+		{Mod: "golang.org/x/sync", Pattern: "*.go", Delete: true},
+		{Pkg: "golang.org/x/sync/errgroup", Path: "foo.go"},
+		{Mod: "golang.org/x/sync", Path: "foo.go", Version: ">= v0.11.0"},
+		// An item without Content or Delete is not written as an empty file.
+		{Mod: "golang.org/x/sync", Path: "foo.go"},
+		{Mod: "example/nodot", Path: "foo.go", Content: []byte("package nodot\n")},
+	} {
+		if err := uwagaki.WritePatchDir(dir, []uwagaki.ReplaceItem{item}); err == nil {
+			t.Errorf("WritePatchDir with %v must fail", item)
+		}
+	}
+}
+
+func TestLoadPatchDirFile(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"github.com/!burnt!sushi/toml@v1.4.0/decode.go":          "package toml\n",
+		"golang.org/x/sync@/errgroup/errgroup.go.uwagaki-delete": "",
+		"golang.org/x/sync@/semaphore/semaphore.go.uwagaki-patch": `--- a/semaphore/semaphore.go
++++ b/semaphore/semaphore.go
+@@ -1 +1 @@
+-package semaphore
++package semaphore // patched
+`,
+	})
+
+	testCases := []struct {
+		mod     string
+		version string
+		path    string
+		file    string
+		content string
+		delete  bool
+		patched bool
+	}{
+		{
+			mod:     "github.com/BurntSushi/toml",
+			version: "v1.4.0",
+			path:    "decode.go",
+			file:    "github.com/!burnt!sushi/toml@v1.4.0/decode.go",
+			content: "package toml\n",
+		},
+		{
+			// The version must match the directory name.
+			mod:  "github.com/BurntSushi/toml",
+			path: "decode.go",
+		},
+		{
+			mod:    "golang.org/x/sync",
+			path:   "errgroup/errgroup.go",
+			file:   "golang.org/x/sync@/errgroup/errgroup.go.uwagaki-delete",
+			delete: true,
+		},
+		{
+			mod:     "golang.org/x/sync",
+			path:    "semaphore/semaphore.go",
+			file:    "golang.org/x/sync@/semaphore/semaphore.go.uwagaki-patch",
+			content: "package semaphore // patched\n",
+			patched: true,
+		},
+	}
+	for _, tc := range testCases {
+		item, file, err := uwagaki.LoadPatchDirFile(dir, tc.mod, tc.version, tc.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.file == "" {
+			if item != nil || file != "" {
+				t.Errorf("LoadPatchDirFile(%q, %q, %q): got: %v, %q, want: nil", tc.mod, tc.version, tc.path, item, file)
+			}
+			continue
+		}
+		if item == nil {
+			t.Errorf("LoadPatchDirFile(%q, %q, %q): got: nil", tc.mod, tc.version, tc.path)
+			continue
+		}
+		if got, want := file, filepath.Join(dir, filepath.FromSlash(tc.file)); got != want {
+			t.Errorf("LoadPatchDirFile(%q, %q, %q): file: got: %s, want: %s", tc.mod, tc.version, tc.path, got, want)
+		}
+		if got, want := item.Path, tc.path; got != want {
+			t.Errorf("LoadPatchDirFile(%q, %q, %q): path: got: %s, want: %s", tc.mod, tc.version, tc.path, got, want)
+		}
+		if got, want := item.Delete, tc.delete; got != want {
+			t.Errorf("LoadPatchDirFile(%q, %q, %q): delete: got: %t, want: %t", tc.mod, tc.version, tc.path, got, want)
+		}
+		content := item.Content
+		if tc.patched {
+			if item.Transform == nil {
+				t.Fatalf("LoadPatchDirFile(%q, %q, %q): Transform must not be nil", tc.mod, tc.version, tc.path)
+			}
+			content, err = item.Transform(tc.path, []byte("package semaphore\n"))
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got, want := string(content), tc.content; got != want {
+			t.Errorf("LoadPatchDirFile(%q, %q, %q): content: got: %q, want: %q", tc.mod, tc.version, tc.path, got, want)
+		}
+	}
+}
+
+func TestLoadPatchDirError(t *testing.T) {
+	for _, files := range []map[string]string{
+		{"golang.org/x/sync/foo.go": ""},
+		{"foo.go": ""},
+		{"github.com/Foo/bar@/foo.go": ""},
+		{"github.com/foo/bar@latest/foo.go": ""},
+	} {
+		dir := t.TempDir()
+		writeFiles(t, dir, files)
+		if _, err := uwagaki.LoadPatchDir(dir); err == nil {
+			t.Errorf("LoadPatchDir with %v must fail", files)
+		}
+	}
+}
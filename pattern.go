@@ -0,0 +1,167 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2025 Hajime Hoshi
+
+package uwagaki
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// matchPattern reports whether the slash-separated name matches the pattern.
+//
+// Each element of the pattern is matched by path.Match, except that an element '**' matches zero or more elements.
+func matchPattern(pattern, name string) bool {
+	return matchElems(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchElems(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// Try all the possible numbers of elements for '**'.
+			for i := 0; i <= len(name); i++ {
+				if matchElems(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pattern[0], name[0]); err != nil || !ok {
+			return false
+		}
+		pattern = pattern[1:]
+		name = name[1:]
+	}
+	return len(name) == 0
+}
+
+// fileChange represents a change of a file in a module.
+type fileChange struct {
+	content []byte
+	deleted bool
+}
+
+// moduleFiles represents the files of a module with changes by ReplaceItems.
+type moduleFiles struct {
+	origDir string
+	changes map[string]fileChange
+
+	// origFiles is a sorted list of the original files. origFiles is initialized lazily.
+	origFiles []string
+}
+
+func newModuleFiles(origDir string) *moduleFiles {
+	return &moduleFiles{
+		origDir: origDir,
+		changes: map[string]fileChange{},
+	}
+}
+
+// read returns the current content of the file at the slash-separated path.
+// read returns fs.ErrNotExist if the file doesn't exist or is deleted.
+func (m *moduleFiles) read(name string) ([]byte, error) {
+	if c, ok := m.changes[name]; ok {
+		if c.deleted {
+			return nil, fs.ErrNotExist
+		}
+		return c.content, nil
+	}
+	return os.ReadFile(filepath.Join(m.origDir, filepath.FromSlash(name)))
+}
+
+// match returns the sorted list of the current files matching the pattern.
+func (m *moduleFiles) match(pattern string) ([]string, error) {
+	if m.origFiles == nil {
+		files, err := listModuleFiles(m.origDir)
+		if err != nil {
+			return nil, err
+		}
+		m.origFiles = files
+	}
+
+	var names []string
+	for _, name := range m.origFiles {
+		if _, ok := m.changes[name]; ok {
+			continue
+		}
+		if matchPattern(pattern, name) {
+			names = append(names, name)
+		}
+	}
+	for name, c := range m.changes {
+		if c.deleted {
+			continue
+		}
+		if matchPattern(pattern, name) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+// apply applies the item to the file at the slash-separated path.
+func (m *moduleFiles) apply(r *ReplaceItem, name string) error {
+	switch {
+	case r.Delete:
+		if _, err := m.read(name); err != nil {
+			return err
+		}
+		m.changes[name] = fileChange{deleted: true}
+	case r.Transform != nil:
+		content, err := m.read(name)
+		if err != nil {
+			return err
+		}
+		content, err = r.Transform(name, content)
+		if err != nil {
+			return err
+		}
+		m.changes[name] = fileChange{content: content}
+	default:
+		m.changes[name] = fileChange{content: r.Content}
+	}
+	return nil
+}
+
+// listModuleFiles returns a sorted list of slash-separated paths of the files in the module directory.
+// Files in .git and nested modules are not included.
+func listModuleFiles(dir string) ([]string, error) {
+	var files []string
+	if err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			if rel != "." {
+				if _, err := os.Stat(filepath.Join(p, "go.mod")); err == nil {
+					return filepath.SkipDir
+				} else if !errors.Is(err, fs.ErrNotExist) {
+					return err
+				}
+			}
+			return nil
+		}
+		files = append(files, filepath.ToSlash(rel))
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	slices.Sort(files)
+	return files, nil
+}
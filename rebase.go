@@ -0,0 +1,204 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2025 Hajime Hoshi
+
+package uwagaki
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"golang.org/x/mod/module"
+
+	"github.com/hajimehoshi/uwagaki/internal/diff"
+)
+
+// RebaseResult represents a result of Rebase.
+type RebaseResult struct {
+	// Items is the list of the rebased ReplaceItems.
+	// The order is the same as the ReplaceItems passed to Rebase.
+	// An item that cannot be rebased is kept as it is, and is reported in Conflicts.
+	// An item that is no longer needed as the file was removed in upstream is omitted, and is reported in Removed.
+	Items []ReplaceItem
+
+	// Removed is the list of the ReplaceItems omitted from Items as the files were removed in upstream.
+	// A deleting item, or an item not changing the file, is removed in this case.
+	Removed []ReplaceItem
+
+	// Conflicts is the list of the files that cannot be rebased automatically.
+	Conflicts []RebaseConflict
+}
+
+// RebaseConflict represents a file that cannot be rebased automatically.
+type RebaseConflict struct {
+	// Path is the slash-separated file path in the module.
+	Path string
+
+	// Reason describes the conflict.
+	Reason string
+
+	// Content is the merged content with conflict markers.
+	// Content is nil if the conflict cannot be represented by conflict markers, e.g., a file modified in the replacement was removed in upstream.
+	Content []byte
+}
+
+// Rebase rebases the ReplaceItems for the module from oldVersion to newVersion.
+//
+// For each item with Path and Content, Rebase merges the changes from the file at oldVersion to Content
+// and the changes from the file at oldVersion to the file at newVersion by a three-way merge.
+// A file deleted by an item is checked to be unchanged in upstream.
+// BaseHash of a rebased item is updated with the file at newVersion.
+//
+// Items for other modules, items specified by Pkg, items with Pattern or Transform,
+// and items whose Version doesn't match oldVersion are kept as they are.
+// Version of a rebased item is not updated, so the caller might have to update it.
+//
+// Rebase downloads the modules by 'go mod download' in the current directory.
+func Rebase(modulePath string, oldVersion, newVersion string, replaces []ReplaceItem) (*RebaseResult, error) {
+	if err := module.Check(modulePath, oldVersion); err != nil {
+		return nil, fmt.Errorf("uwagaki: invalid module version: %w", err)
+	}
+	if err := module.Check(modulePath, newVersion); err != nil {
+		return nil, fmt.Errorf("uwagaki: invalid module version: %w", err)
+	}
+
+	oldDir, err := downloadModule(modulePath, oldVersion)
+	if err != nil {
+		return nil, err
+	}
+	newDir, err := downloadModule(modulePath, newVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &RebaseResult{}
+	for _, r := range replaces {
+		if r.Mod != modulePath || r.Path == "" || r.Transform != nil {
+			result.Items = append(result.Items, r)
+			continue
+		}
+		if r.Version != "" {
+			c, err := parseVersionConstraint(r.Version)
+			if err != nil {
+				return nil, err
+			}
+			if !c.match(oldVersion) {
+				result.Items = append(result.Items, r)
+				continue
+			}
+		}
+		if err := validateReplaceItem(&r, nil); err != nil {
+			return nil, err
+		}
+
+		oldContent, oldExists, err := readModuleFile(oldDir, r.Path)
+		if err != nil {
+			return nil, err
+		}
+		newContent, newExists, err := readModuleFile(newDir, r.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		conflict := func(reason string, content []byte) {
+			result.Items = append(result.Items, r)
+			result.Conflicts = append(result.Conflicts, RebaseConflict{
+				Path:    r.Path,
+				Reason:  reason,
+				Content: content,
+			})
+		}
+
+		if r.Delete {
+			switch {
+			case !newExists:
+				// The file was removed in upstream. The item is no longer needed.
+				result.Removed = append(result.Removed, r)
+			case oldExists && bytes.Equal(oldContent, newContent):
+				if r.BaseHash != "" {
+					r.BaseHash = Hash(newContent)
+				}
+				result.Items = append(result.Items, r)
+			default:
+				conflict("the deleted file was changed in upstream", nil)
+			}
+			continue
+		}
+
+		switch {
+		case oldExists && !newExists:
+			if bytes.Equal(oldContent, r.Content) {
+				// The item doesn't change the file, and the file was removed in upstream. The item is no longer needed.
+				result.Removed = append(result.Removed, r)
+				continue
+			}
+			conflict("the replaced file was removed in upstream", nil)
+			continue
+		case oldExists && bytes.Equal(oldContent, newContent):
+			// The file is not changed in upstream.
+			if r.BaseHash != "" {
+				r.BaseHash = Hash(newContent)
+			}
+			result.Items = append(result.Items, r)
+			continue
+		}
+
+		// If the file doesn't exist at oldVersion, the file was added by the item, and the base is empty.
+		merged, n := diff.Merge(oldContent, r.Content, newContent, diff.Labels{
+			Ours:   "replacement",
+			Base:   modulePath + "@" + oldVersion,
+			Theirs: modulePath + "@" + newVersion,
+		})
+		if n > 0 {
+			conflict(fmt.Sprintf("%d conflict(s) in the three-way merge", n), merged)
+			continue
+		}
+		r.Content = merged
+		if r.BaseHash != "" {
+			r.BaseHash = Hash(newContent)
+		}
+		result.Items = append(result.Items, r)
+	}
+	return result, nil
+}
+
+// downloadModule downloads the module and returns its directory.
+func downloadModule(modulePath, version string) (string, error) {
+	cmd := exec.Command("go", "mod", "download", "-json", modulePath+"@"+version)
+	out, err := cmd.Output()
+	if err != nil {
+		// 'go mod download -json' reports an error in the JSON output.
+		var m struct {
+			Error string
+		}
+		if json.Unmarshal(out, &m) == nil && m.Error != "" {
+			return "", fmt.Errorf("uwagaki: '%s' failed: %s", cmd, m.Error)
+		}
+		return "", fmt.Errorf("uwagaki: '%s' failed: %w", cmd, err)
+	}
+	var m struct {
+		Dir string
+	}
+	if err := json.Unmarshal(out, &m); err != nil {
+		return "", err
+	}
+	return m.Dir, nil
+}
+
+// readModuleFile reads the file at the slash-separated path in the module directory.
+// readModuleFile returns false as exists if the file doesn't exist.
+func readModuleFile(dir string, path string) (content []byte, exists bool, err error) {
+	content, err = os.ReadFile(filepath.Join(dir, filepath.FromSlash(path)))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return content, true, nil
+}
@@ -0,0 +1,128 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2025 Hajime Hoshi
+
+package uwagaki_test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hajimehoshi/uwagaki"
+)
+
+func syncModuleFile(t *testing.T, version string, path string) []byte {
+	out, err := exec.Command("go", "mod", "download", "-json", "golang.org/x/sync@"+version).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, dir, ok := strings.Cut(string(out), `"Dir": "`)
+	if !ok {
+		t.Fatalf("unexpected output: %s", out)
+	}
+	dir, _, _ = strings.Cut(dir, `"`)
+	content, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(path)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+func TestRebase(t *testing.T) {
+	// Only errgroup/errgroup.go differs between v0.10.0 and v0.11.0: a comment line is added to SetLimit.
+	orig := string(syncModuleFile(t, "v0.10.0", "errgroup/errgroup.go"))
+	updated := string(syncModuleFile(t, "v0.11.0", "errgroup/errgroup.go"))
+	const limitComment = "// A limit of zero will prevent any new goroutines from being added.\n"
+	if !strings.Contains(updated, limitComment) {
+		t.Fatalf("errgroup.go at v0.11.0 must include %q", limitComment)
+	}
+
+	mergeable := strings.Replace(orig, "package errgroup", "package errgroup // patched", 1)
+	conflicting := strings.Replace(orig, "// A negative value indicates no limit.\n", "// A negative value indicates no limit (patched).\n", 1)
+	unchanged := syncModuleFile(t, "v0.10.0", "semaphore/semaphore.go")
+
+	replaces := []uwagaki.ReplaceItem{
+		{Mod: "golang.org/x/sync", Path: "errgroup/errgroup.go", Content: []byte(mergeable), BaseHash: uwagaki.Hash([]byte(orig))},
+		{Mod: "golang.org/x/sync", Path: "errgroup/errgroup.go", Content: []byte(conflicting), Version: "v0.9.0"},
+		{Mod: "golang.org/x/sync", Path: "semaphore/semaphore.go", Content: unchanged},
+		{Mod: "golang.org/x/sync", Path: "additional_file_by_uwagaki.go", Content: []byte("package sync\n")},
+		{Mod: "golang.org/x/text", Path: "foo.go", Content: []byte("package text\n")},
+	}
+	result, err := uwagaki.Rebase("golang.org/x/sync", "v0.10.0", "v0.11.0", replaces)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Conflicts) > 0 {
+		t.Errorf("conflicts: got: %v, want: none", result.Conflicts)
+	}
+	if got, want := len(result.Items), len(replaces); got != want {
+		t.Fatalf("len(result.Items): got: %d, want: %d", got, want)
+	}
+	if got, want := string(result.Items[0].Content), strings.Replace(updated, "package errgroup", "package errgroup // patched", 1); got != want {
+		t.Errorf("merged content: got: %q, want: %q", got, want)
+	}
+	if got, want := result.Items[0].BaseHash, uwagaki.Hash([]byte(updated)); got != want {
+		t.Errorf("BaseHash: got: %s, want: %s", got, want)
+	}
+	// The item for another version is kept.
+	if got, want := string(result.Items[1].Content), conflicting; got != want {
+		t.Errorf("content for another version: got: %q, want: %q", got, want)
+	}
+	for i := 2; i < len(replaces); i++ {
+		if got, want := string(result.Items[i].Content), string(replaces[i].Content); got != want {
+			t.Errorf("content %d: got: %q, want: %q", i, got, want)
+		}
+	}
+
+	// A change touching the upstream change is a conflict.
+	result, err = uwagaki.Rebase("golang.org/x/sync", "v0.10.0", "v0.11.0", []uwagaki.ReplaceItem{
+		{Mod: "golang.org/x/sync", Path: "errgroup/errgroup.go", Content: []byte(conflicting)},
+		{Mod: "golang.org/x/sync", Path: "errgroup/errgroup.go", Delete: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(result.Conflicts), 2; got != want {
+		t.Fatalf("len(result.Conflicts): got: %d, want: %d", got, want)
+	}
+	if c := result.Conflicts[0]; !strings.Contains(string(c.Content), "<<<<<<< replacement\n") || !strings.Contains(string(c.Content), ">>>>>>> golang.org/x/sync@v0.11.0\n") {
+		t.Errorf("the conflict must have markers: %s", c.Content)
+	}
+	if c := result.Conflicts[1]; c.Content != nil {
+		t.Errorf("the conflict for a deletion must not have content: %s", c.Content)
+	}
+	if got, want := string(result.Items[0].Content), conflicting; got != want {
+		t.Errorf("the conflicting item must be kept: got: %q, want: %q", got, want)
+	}
+
+	// Items for the files removed in upstream are reported in Removed. errgroup/go120.go and its friends are removed at v0.23.0.
+	replaces = []uwagaki.ReplaceItem{
+		{Mod: "golang.org/x/sync", Path: "errgroup/go120_test.go", Delete: true},
+		{Mod: "golang.org/x/sync", Path: "additional_file_by_uwagaki.go", Content: []byte("package sync\n")},
+		{Mod: "golang.org/x/sync", Path: "errgroup/pre_go120.go", Content: syncModuleFile(t, "v0.11.0", "errgroup/pre_go120.go")},
+	}
+	result, err = uwagaki.Rebase("golang.org/x/sync", "v0.11.0", "v0.23.0", replaces)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Conflicts) > 0 {
+		t.Errorf("conflicts: got: %v, want: none", result.Conflicts)
+	}
+	if got, want := len(result.Items), 1; got != want {
+		t.Fatalf("len(result.Items): got: %d, want: %d", got, want)
+	}
+	if got, want := result.Items[0].Path, "additional_file_by_uwagaki.go"; got != want {
+		t.Errorf("result.Items[0].Path: got: %s, want: %s", got, want)
+	}
+	if got, want := len(result.Removed), 2; got != want {
+		t.Fatalf("len(result.Removed): got: %d, want: %d", got, want)
+	}
+	if got, want := result.Removed[0].Path, "errgroup/go120_test.go"; got != want {
+		t.Errorf("result.Removed[0].Path: got: %s, want: %s", got, want)
+	}
+	if got, want := result.Removed[1].Path, "errgroup/pre_go120.go"; got != want {
+		t.Errorf("result.Removed[1].Path: got: %s, want: %s", got, want)
+	}
+}
@@ -5,18 +5,28 @@ package uwagaki
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
 	"regexp"
+	"runtime/debug"
+	"slices"
 	"strings"
 	"time"
 
 	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
 )
 
 // ReplaceItem represents a file replacement.
@@ -24,13 +34,59 @@ type ReplaceItem struct {
 	// Mod is a module path.
 	Mod string
 
+	// Pkg is a package import path.
+	// If Pkg is specified, Mod must be empty and Path must be a file name in the package.
+	// The module is determined from the package in the environment,
+	// so callers don't have to know the module boundary.
+	Pkg string
+
 	// Path is a file path in the module.
 	// Path's separator is slash.
 	// Path must be a regulra file path, not a directory path.
+	// Path must be clean and relative, and must not include '..' or '.git' elements. See also Validate.
 	Path string
 
+	// Pattern is a slash-separated glob pattern of file paths in the module, like 'internal/**/*_test.go'.
+	// Each element of Pattern is matched by path.Match, except that an element '**' matches zero or more directories.
+	// If Pkg is specified, Pattern must be a pattern of file names in the package.
+	//
+	// Path and Pattern are exclusive, and Pattern requires Delete or Transform.
+	// Pattern matches the files in the module after applying the previous items, except for files in nested modules.
+	// It is an error if Pattern matches no files.
+	Pattern string
+
 	// Content is a file content.
 	Content []byte
+
+	// Delete indicates that the file is removed.
+	// It is an error if the file doesn't exist.
+	Delete bool
+
+	// Transform is a function to replace a file content with its result.
+	// Transform is called with the slash-separated path in the module and the current content,
+	// which is the original content or the content replaced by the previous items.
+	// It is an error if the file doesn't exist.
+	//
+	// Transform is not taken into account for the environment name.
+	// Content, Delete, and Transform are exclusive.
+	Transform func(path string, content []byte) ([]byte, error)
+
+	// Version is a constraint of the module version like '>= v0.22.0, < v0.25.0'.
+	// Version is a comma-separated list of comparisons with semantic versions, and all the comparisons must be satisfied.
+	// The operators are '=', '!=', '<', '<=', '>', and '>='. A version without an operator means '='.
+	//
+	// If Version is not empty, the item is applied only when the module version in the environment satisfies the constraint.
+	// Items with different constraints can target the same file to support several versions of the module.
+	// It is an error if no items for a module are applied, or if the module is a main module without a version.
+	Version string
+
+	// BaseHash is the expected hash of the original file at Path, in the format of Hash like 'sha256:<hex>'.
+	//
+	// If BaseHash is not empty, the original file in the module is compared with BaseHash,
+	// so that a replacement doesn't overwrite a file changed in upstream silently.
+	// See also Options.OnDrift.
+	// BaseHash cannot be used with Pattern.
+	BaseHash string
 }
 
 // CreateEnvironment returns a new directory where you can run go commands,
@@ -39,6 +95,13 @@ type ReplaceItem struct {
 // The returned paths can be passed to Go commands like 'go run' in the new environment.
 //
 // paths is a list of package paths that is passed to 'go get' command to create go.mod.
+// If no module in the environment provides a package, the module is added to the environment.
+// paths can include Go files like 'main.go' and patterns like './...'.
+// A local path outside the main module is an error.
+// A path can have a version suffix like 'golang.org/x/text/language@v0.22.0'.
+// The version is pinned in the environment with the replacements applied, and the returned path doesn't have the version suffix,
+// as go commands ignore go.mod for a path with a version suffix.
+// It is an error if the version cannot be selected, e.g. when the current module requires a higher version.
 //
 // The returned directory is temporary and you should remove it after using it.
 //
@@ -48,43 +111,383 @@ type ReplaceItem struct {
 // Usually, Go's -overlay flag cannot be used for external modules (see https://go.dev/cl/650475).
 // CreateEnvironment creates a temporary environment to replace files in external modules by go.mod.
 func CreateEnvironment(paths []string, replaces []ReplaceItem) (workDir string, newPaths []string, err error) {
-	work, err := os.MkdirTemp("", "")
+	e, err := NewEnvironment(paths, replaces, nil)
 	if err != nil {
 		return "", nil, err
 	}
+	return e.Dir(), e.Paths(), nil
+}
 
-	// If the current directory has go.mod, use this.
-	var currentGoMod string
-	{
-		cmd := exec.Command("go", "env", "GOMOD")
-		out, err := cmd.Output()
-		if err == nil {
-			// Ignore the error.
-			currentGoMod = strings.TrimSpace(string(out))
+// Environment represents an environment created by NewEnvironment.
+type Environment struct {
+	dir        string
+	workingDir string
+	paths      []string
+	layout     Layout
+
+	// origPaths is a list of the paths passed to NewEnvironment.
+	// The i-th path corresponds to the i-th path of paths.
+	origPaths []string
+
+	// pathModules is a list of the modules providing paths.
+	pathModules []module.Version
+
+	// mainModules is a list of the main modules used in the environment.
+	// The first item is the current module if exists.
+	mainModules []mainModule
+
+	// modules is a map from a module path to the module copied to the environment.
+	modules map[string]*envModule
+
+	replaces []ReplaceItem
+
+	// replaced is a set of files replaced in the environment.
+	replaced map[replacedFile]struct{}
+
+	onDrift func(*DriftError) error
+
+	// created is the time when the environment was created.
+	created time.Time
+
+	// currentDir is the current directory when the environment was created.
+	currentDir string
+
+	// pid is the process ID of the creator. pid is 0 for a detached environment.
+	pid int
+
+	// hostname is the host name of the creator.
+	hostname string
+}
+
+type mainModule struct {
+	// path is a module path.
+	path string
+
+	// dir is an absolute directory path.
+	dir string
+}
+
+// envModule represents a module copied to the environment.
+type envModule struct {
+	// version is the module version. version is empty for a main module.
+	version string
+
+	// origDir is the original directory of the module.
+	origDir string
+
+	// dir is the directory of the copied module in the environment.
+	dir string
+}
+
+type replacedFile struct {
+	mod  string
+	path string
+}
+
+// Options represents options for NewEnvironment.
+type Options struct {
+	// ModuleName is the module path of the environment's go.mod.
+	//
+	// If ModuleName is empty, a name derived from the hash of the inputs is used,
+	// so the same inputs always result in the same module name.
+	ModuleName string
+
+	// Dir is the environment directory.
+	//
+	// If Dir is empty, a new temporary directory with the prefix 'uwagaki-' is created. See also GC.
+	// Otherwise, Dir is created and must not exist, so two environments never share the same directory.
+	Dir string
+
+	// Layout is the layout of the environment.
+	// The default is LayoutModule.
+	Layout Layout
+
+	// Modules is a list of module directories used as additional main modules in LayoutWorkspace.
+	// Modules must be empty for the other layouts.
+	Modules []string
+
+	// Require is a list of module versions required in the environment, like 'go get golang.org/x/sync@v0.11.0'.
+	// The versions are applied before resolving paths and replacing files, so the replacements are applied to these versions.
+	// With LayoutModule, a version lower than the current module requires cannot be selected, as the current module is a dependency.
+	// With LayoutWorkspace, a version lower than the main modules require cannot be selected, as the versions are selected across the workspace.
+	// NewEnvironment fails if a required version cannot be selected.
+	Require []module.Version
+
+	// Tools is a list of package paths of tools added to the environment by tool directives, like 'go get -tool'.
+	// A path can have a version suffix like 'golang.org/x/tools/cmd/stringer@v0.30.0'.
+	// The tools are added before replacing files, so the replacements are applied to the tools too.
+	//
+	// Tool directives in the current go.mod are available without Tools. See Environment.ToolCommand.
+	Tools []string
+
+	// OnDrift is called when an original file differs from ReplaceItem.BaseHash.
+	// If OnDrift returns an error, creating or updating the environment fails with the error.
+	// For example, OnDrift can log the error and return nil to just warn.
+	//
+	// If OnDrift is nil, the *DriftError is returned.
+	OnDrift func(err *DriftError) error
+
+	// Detached indicates that the environment is used after the current process exits,
+	// e.g., the environment directory is passed to another process.
+	// GC doesn't remove a detached environment because of its creator process.
+	Detached bool
+}
+
+// Layout represents how an environment redirects modules to the replaced files.
+type Layout int
+
+const (
+	// LayoutModule is a layout where the environment has its own main module.
+	// The environment's go.mod is a copy of the current go.mod with a different module name,
+	// and requires the current module as a dependency.
+	//
+	// Binaries built in this layout report the environment's module as the main module in their build information.
+	LayoutModule Layout = iota
+
+	// LayoutWorkspace is a layout where the environment has go.work using the current module and a helper module.
+	// The current module is used as it is, so binaries built in this layout keep the current module as the main module,
+	// and the dependency versions are the same as the current module's.
+	// The replaced modules are redirected by replace directives in go.work, so that their versions in the build list are kept.
+	// See also PatchedModules.
+	//
+	// If the current directory is in a workspace, the workspace's modules and replace directives are used too.
+	// Options.Modules can add more main modules.
+	//
+	// Go commands can run either in Environment.Dir or in other directories with GOWORK in Environment.Env.
+	//
+	// LayoutWorkspace requires at least one module in the current directory, the current workspace, or Options.Modules.
+	LayoutWorkspace
+
+	// LayoutModFile is a layout where the environment has an alternate go.mod and go.sum for the current module.
+	// Go commands run in the current directory with the -modfile flag (see Environment.WorkingDir and Environment.Env),
+	// so relative paths work as they are.
+	// The paths passed to NewEnvironment are returned by Environment.Paths without changes.
+	//
+	// The current module itself cannot be replaced in this layout.
+	//
+	// LayoutModFile requires go.mod in the current directory or its parent directories.
+	LayoutModFile
+)
+
+// NewEnvironment creates a new environment.
+// See CreateEnvironment for details.
+//
+// Unlike CreateEnvironment, the returned environment can be updated by Update.
+// You should call Close after using it.
+//
+// options can be nil.
+func NewEnvironment(paths []string, replaces []ReplaceItem, options *Options) (env *Environment, err error) {
+	if options == nil {
+		options = &Options{}
+	}
+	switch options.Layout {
+	case LayoutModule, LayoutWorkspace, LayoutModFile:
+	default:
+		return nil, fmt.Errorf("uwagaki: invalid layout: %d", options.Layout)
+	}
+	if len(options.Modules) > 0 && options.Layout != LayoutWorkspace {
+		return nil, fmt.Errorf("uwagaki: Options.Modules is available only with LayoutWorkspace")
+	}
+	for _, m := range options.Require {
+		if err := module.Check(m.Path, m.Version); err != nil {
+			return nil, fmt.Errorf("uwagaki: invalid Options.Require: %w", err)
+		}
+	}
+	for _, tool := range options.Tools {
+		if err := checkTool(tool); err != nil {
+			return nil, fmt.Errorf("uwagaki: invalid Options.Tools: %w", err)
 		}
-		// GOMOD can be os.DevNull, and ignore it in that case.
-		if currentGoMod == os.DevNull {
-			currentGoMod = ""
+	}
+
+	// Validate the items before creating the environment. Module paths are validated later when the main modules are determined.
+	for i := range replaces {
+		if err := validateReplaceItem(&replaces[i], func(string) bool { return true }); err != nil {
+			return nil, err
 		}
 	}
+	if err := checkConflicts(0, replaces); err != nil {
+		return nil, err
+	}
 
-	randomModuleName := "uwagaki_" + time.Now().UTC().Format("20060102150405")
+	// If the current directory has go.mod, use this.
+	currentGoMod := goEnv("GOMOD")
+	// GOMOD can be os.DevNull, and ignore it in that case.
+	if currentGoMod == os.DevNull {
+		currentGoMod = ""
+	}
 
-	var currentModPath string
+	var currentGoModContent []byte
 	if currentGoMod != "" {
-		// Copy the current go.mod and go.sum to the work directory, but with modifying the module name.
 		content, err := os.ReadFile(currentGoMod)
 		if err != nil {
-			return "", nil, err
+			return nil, err
+		}
+		currentGoModContent = content
+	}
+	if currentGoMod == "" && options.Layout == LayoutModFile {
+		return nil, fmt.Errorf("uwagaki: LayoutModFile requires go.mod")
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	moduleName := options.ModuleName
+	if moduleName == "" {
+		moduleName = environmentModuleName(currentGoModContent, paths, replaces, options)
+	} else if err := module.CheckImportPath(moduleName); err != nil {
+		return nil, fmt.Errorf("uwagaki: invalid module name: %w", err)
+	}
+
+	var work string
+	if options.Dir != "" {
+		if err := os.MkdirAll(filepath.Dir(options.Dir), 0755); err != nil {
+			return nil, err
+		}
+		// os.Mkdir fails if the directory already exists.
+		if err := os.Mkdir(options.Dir, 0755); err != nil {
+			return nil, err
+		}
+		abs, err := filepath.Abs(options.Dir)
+		if err != nil {
+			_ = os.Remove(options.Dir)
+			return nil, err
+		}
+		work = abs
+	} else {
+		dir, err := os.MkdirTemp("", tempDirPrefix)
+		if err != nil {
+			return nil, err
+		}
+		work = dir
+	}
+	defer func() {
+		if err != nil {
+			_ = os.RemoveAll(work)
+		}
+	}()
+
+	e := &Environment{
+		dir:        work,
+		workingDir: work,
+		layout:     options.Layout,
+		modules:    map[string]*envModule{},
+		replaced:   map[replacedFile]struct{}{},
+		onDrift:    options.OnDrift,
+		created:    time.Now(),
+		currentDir: wd,
+	}
+	if !options.Detached {
+		e.pid = os.Getpid()
+		e.hostname, _ = os.Hostname()
+	}
+	// Write the metadata first so that GC can identify the environment even if the current process crashes.
+	if err := e.writeMetadata(); err != nil {
+		return nil, err
+	}
+
+	switch {
+	case options.Layout == LayoutWorkspace:
+		if currentGoMod != "" {
+			e.mainModules = append(e.mainModules, mainModule{
+				path: modfile.ModulePath(currentGoModContent),
+				dir:  filepath.Dir(currentGoMod),
+			})
+		}
+
+		// Use the modules and the replace directives in the current workspace.
+		var workReplaces []*modfile.Replace
+		if goWork := goEnv("GOWORK"); goWork != "" && goWork != "off" {
+			content, err := os.ReadFile(goWork)
+			if err != nil {
+				return nil, err
+			}
+			wf, err := modfile.ParseWork(goWork, content, nil)
+			if err != nil {
+				return nil, err
+			}
+			for _, u := range wf.Use {
+				dir := u.Path
+				if !filepath.IsAbs(dir) {
+					dir = filepath.Join(filepath.Dir(goWork), dir)
+				}
+				if err := e.addMainModule(dir); err != nil {
+					return nil, err
+				}
+			}
+			for _, r := range wf.Replace {
+				if modfile.IsDirectoryPath(r.New.Path) && !filepath.IsAbs(r.New.Path) {
+					r.New.Path = filepath.Join(filepath.Dir(goWork), r.New.Path)
+				}
+				workReplaces = append(workReplaces, r)
+			}
+		}
+		for _, dir := range options.Modules {
+			abs, err := filepath.Abs(dir)
+			if err != nil {
+				return nil, err
+			}
+			if err := e.addMainModule(abs); err != nil {
+				return nil, err
+			}
+		}
+		if len(e.mainModules) == 0 {
+			return nil, fmt.Errorf("uwagaki: LayoutWorkspace requires at least one module")
+		}
+
+		// The helper module is used to add modules that the main modules don't require.
+		if _, err := e.runGo("mod", "init", moduleName); err != nil {
+			return nil, err
+		}
+		args := []string{"work", "init", "."}
+		for _, m := range e.mainModules {
+			args = append(args, m.dir)
+		}
+		if _, err := e.runGo(args...); err != nil {
+			return nil, err
+		}
+		for _, r := range workReplaces {
+			old := r.Old.Path
+			if r.Old.Version != "" {
+				old += "@" + r.Old.Version
+			}
+			newPath := r.New.Path
+			if r.New.Version != "" {
+				newPath += "@" + r.New.Version
+			}
+			if _, err := e.runGo("work", "edit", "-replace", old+"="+newPath); err != nil {
+				return nil, err
+			}
+		}
+	case options.Layout == LayoutModFile:
+		e.mainModules = append(e.mainModules, mainModule{
+			path: modfile.ModulePath(currentGoModContent),
+			dir:  filepath.Dir(currentGoMod),
+		})
+		e.workingDir = wd
+
+		// Copy the current go.mod and go.sum as they are.
+		// Relative paths in replace directives are still relative to the current module's directory.
+		if err := os.WriteFile(filepath.Join(work, "go.mod"), currentGoModContent, 0644); err != nil {
+			return nil, err
+		}
+		if err := copyGoSum(currentGoMod, filepath.Join(work, "go.sum")); err != nil {
+			return nil, err
 		}
-		mod, err := modfile.Parse(currentGoMod, content, nil)
+	case currentGoMod != "":
+		// Copy the current go.mod and go.sum to the work directory, but with modifying the module name.
+		mod, err := modfile.Parse(currentGoMod, currentGoModContent, nil)
 		if err != nil {
-			return "", nil, err
+			return nil, err
 		}
 		origModPath := mod.Module.Mod.Path
-		currentModPath = origModPath
-		if err := mod.AddModuleStmt(randomModuleName); err != nil {
-			return "", nil, err
+		e.mainModules = append(e.mainModules, mainModule{
+			path: origModPath,
+			dir:  filepath.Dir(currentGoMod),
+		})
+		if err := mod.AddModuleStmt(moduleName); err != nil {
+			return nil, err
 		}
 
 		// Fix the 'replace' paths.
@@ -108,148 +511,913 @@ func CreateEnvironment(paths []string, replaces []ReplaceItem) (workDir string,
 			v = "v" + (m[1] + ".0.0")
 		}
 		if err := mod.AddRequire(origModPath, v); err != nil {
-			return "", nil, err
+			return nil, err
 		}
 
 		// Add a replace directive.
 		if err := mod.AddReplace(origModPath, "", filepath.Dir(currentGoMod), ""); err != nil {
-			return "", nil, err
+			return nil, err
 		}
 
 		// Write the new go.mod.
 		content2, err := mod.Format()
 		if err != nil {
-			return "", nil, err
+			return nil, err
 		}
 		if err := os.WriteFile(filepath.Join(work, "go.mod"), content2, 0644); err != nil {
-			return "", nil, err
+			return nil, err
 		}
 
-		// Copy go.sum if exists.
-		goSum := strings.TrimSuffix(currentGoMod, ".mod") + ".sum"
-		if _, err := os.Stat(goSum); err == nil {
-			content, err := os.ReadFile(goSum)
-			if err != nil {
-				return "", nil, err
-			}
-			if err := os.WriteFile(filepath.Join(work, "go.sum"), content, 0644); err != nil {
-				return "", nil, err
-			}
+		if err := copyGoSum(currentGoMod, filepath.Join(work, "go.sum")); err != nil {
+			return nil, err
 		}
-	} else {
+	default:
 		// go mod init
-		var buf bytes.Buffer
-		cmd := exec.Command("go", "mod", "init", randomModuleName)
-		cmd.Stderr = &buf
-		cmd.Dir = work
-		if err := cmd.Run(); err != nil {
-			return "", nil, fmt.Errorf("uwagaki: '%s' failed: %w\n%s", strings.Join(cmd.Args, " "), err, buf.String())
+		if _, err := e.runGo("mod", "init", moduleName); err != nil {
+			return nil, err
 		}
 	}
 
-	replacedModDir := filepath.Join(work, "mod")
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
 
-	modPaths := map[string]string{}
-	for _, r := range replaces {
-		if _, ok := modPaths[r.Mod]; !ok {
-			// go get
-			{
-				var buf bytes.Buffer
-				cmd := exec.Command("go", "get", r.Mod+"/...")
-				cmd.Stderr = &buf
-				cmd.Dir = work
-				if err := cmd.Run(); err != nil {
-					return "", nil, fmt.Errorf("uwagaki: '%s' failed: %w\n%s", strings.Join(cmd.Args, " "), err, buf.String())
-				}
+	if len(options.Require) > 0 {
+		args := []string{"get"}
+		for _, m := range options.Require {
+			args = append(args, m.String())
+		}
+		if _, err := e.runGo(args...); err != nil {
+			return nil, err
+		}
+		for _, m := range options.Require {
+			if err := e.checkSelectedVersion(m.Path, m.Version); err != nil {
+				return nil, err
 			}
-			// go list
-			var modFilepath string
-			{
-				var buf bytes.Buffer
-				cmd := exec.Command("go", "list", "-m", "-f", "{{.Dir}}", r.Mod)
-				cmd.Stderr = &buf
-				cmd.Dir = work
-				out, err := cmd.Output()
-				if err != nil {
-					return "", nil, fmt.Errorf("uwagaki: '%s' failed: %w\n%s", strings.Join(cmd.Args, " "), err, buf.String())
-				}
-				modFilepath = strings.TrimSpace(string(out))
+		}
+	}
+
+	// Add the tools. A tool without a version uses the module in the build list if exists, like the paths.
+	for _, tool := range options.Tools {
+		if strings.Contains(tool, "@") {
+			if _, err := e.runGo("get", "-tool", tool); err != nil {
+				return nil, err
 			}
+			continue
+		}
+		if _, err := e.runGo("mod", "edit", "-tool="+tool, filepath.Join(work, "go.mod")); err != nil {
+			return nil, err
+		}
+		if _, err := e.resolvePackageModule(tool); err != nil {
+			return nil, err
+		}
+	}
 
-			if err := replace(work, replacedModDir, r.Mod, modFilepath); err != nil {
-				return "", nil, err
+	// Pin the versions of the paths with version suffixes like 'golang.org/x/text/language@v0.22.0'.
+	// 'go run pkg@version' ignores go.mod and then the replace directives, so the versions are added to the environment instead.
+	for _, pkg := range paths {
+		if modfile.IsDirectoryPath(pkg) || !strings.Contains(pkg, "@") {
+			continue
+		}
+		if _, err := e.runGo("get", pkg); err != nil {
+			return nil, err
+		}
+		pkgPath, version, _ := strings.Cut(pkg, "@")
+		m, err := e.resolvePackageModule(pkgPath)
+		if err != nil {
+			return nil, err
+		}
+		if m.Path == "" {
+			continue
+		}
+		if err := e.checkSelectedVersion(m.Path, version); err != nil {
+			return nil, err
+		}
+	}
+
+	// Resolve the modules providing the paths before replacing files, as 'go get' might change the module versions.
+	newPaths := make([]string, len(paths))
+	e.pathModules = make([]module.Version, len(paths))
+	for i, pkg := range paths {
+		newPath, m, resolve, err := e.translatePath(pkg)
+		if err != nil {
+			return nil, err
+		}
+		if resolve {
+			m, err = e.resolvePackageModule(newPath)
+			if err != nil {
+				return nil, err
 			}
+		}
+		newPaths[i] = newPath
+		e.pathModules[i] = m
+	}
+	e.paths = newPaths
+	e.origPaths = slices.Clone(paths)
+
+	if err := e.Update(replaces); err != nil {
+		return nil, err
+	}
+
+	// Run go mod downlaod
+	if _, err := e.runGo("mod", "download"); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// checkTool checks a tool's package path with an optional version suffix.
+func checkTool(tool string) error {
+	path, version, ok := strings.Cut(tool, "@")
+	if err := module.CheckImportPath(path); err != nil {
+		return err
+	}
+	if ok && version == "" {
+		return fmt.Errorf("empty version: %s", tool)
+	}
+	return nil
+}
+
+// Dir returns the environment directory.
+// Dir is removed by Close.
+//
+// Dir includes a metadata file .uwagaki-env.json so that the environment can be opened by OpenEnvironment later.
+func (e *Environment) Dir() string {
+	return e.dir
+}
+
+// WorkingDir returns the directory where you can run go commands.
+//
+// For LayoutModFile, WorkingDir is the current directory when the environment was created.
+// Otherwise, WorkingDir is the same as Dir.
+func (e *Environment) WorkingDir() string {
+	return e.workingDir
+}
+
+// ModFile returns the path of the alternate go.mod for LayoutModFile.
+// Pass this to go commands with the -modfile flag.
+//
+// ModFile returns an empty string for the other layouts.
+func (e *Environment) ModFile() string {
+	if e.layout != LayoutModFile {
+		return ""
+	}
+	return filepath.Join(e.dir, "go.mod")
+}
+
+// Env returns a list of environment variables in the form "key=value" to run go commands in the environment.
+//
+// For LayoutWorkspace, Env includes GOWORK, and GOFLAGS without the -mod flag in the current GOFLAGS, as -mod=mod is not available in the workspace mode.
+// For LayoutModFile, Env includes GOFLAGS with the -modfile flag appended to the current GOFLAGS.
+//
+// If the environment has patched modules, Env includes GOFLAGS with the -ldflags flag to record them in the build information of binaries.
+// See PatchedModules.
+// The -ldflags flag is not added if the current GOFLAGS already has -ldflags, and is overridden by -ldflags in the command line.
+//
+// The current GOFLAGS is the value of 'go env GOFLAGS', including the value set by 'go env -w'.
+//
+// Note that GOFLAGS cannot represent a path with spaces.
+func (e *Environment) Env() []string {
+	var env []string
+	origFlags := strings.Fields(goEnv("GOFLAGS"))
+	flags := slices.Clone(origFlags)
+	switch e.layout {
+	case LayoutWorkspace:
+		env = append(env, "GOWORK="+filepath.Join(e.dir, "go.work"))
+		flags = slices.DeleteFunc(flags, func(flag string) bool {
+			return goFlagName(flag) == "mod"
+		})
+	case LayoutModFile:
+		flags = append(flags, "-modfile="+e.ModFile())
+	}
+	if mods := e.patchedModules(); len(mods) > 0 && !slices.ContainsFunc(flags, func(flag string) bool {
+		return goFlagName(flag) == "ldflags"
+	}) {
+		flags = append(flags, "-ldflags=-X="+patchedModulesSymbol+"="+strings.Join(mods, ","))
+	}
+	if !slices.Equal(flags, origFlags) {
+		env = append(env, "GOFLAGS="+strings.Join(flags, " "))
+	}
+	return env
+}
 
-			modPaths[r.Mod] = modFilepath
+// goFlagName returns the name of a flag in GOFLAGS like "-mod=mod".
+func goFlagName(flag string) string {
+	name, _, _ := strings.Cut(strings.TrimLeft(flag, "-"), "=")
+	return name
+}
+
+// patchedModules returns the patched modules in the form of "path@version", or "path" for a main module.
+func (e *Environment) patchedModules() []string {
+	var mods []string
+	for f := range e.replaced {
+		mod := f.mod
+		if m, ok := e.modules[f.mod]; ok && m.version != "" {
+			mod += "@" + m.version
+		}
+		mods = append(mods, mod)
+	}
+	slices.Sort(mods)
+	return slices.Compact(mods)
+}
+
+func (e *Environment) addMainModule(dir string) error {
+	content, err := os.ReadFile(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		return err
+	}
+	if slices.ContainsFunc(e.mainModules, func(m mainModule) bool {
+		return m.dir == dir
+	}) {
+		return nil
+	}
+	e.mainModules = append(e.mainModules, mainModule{
+		path: modfile.ModulePath(content),
+		dir:  dir,
+	})
+	return nil
+}
+
+// mainModule returns the main module of the given module path.
+func (e *Environment) mainModule(modulePath string) (mainModule, bool) {
+	for _, m := range e.mainModules {
+		if m.path == modulePath {
+			return m, true
 		}
+	}
+	return mainModule{}, false
+}
+
+// mainModuleForDir returns the innermost main module including the given absolute directory path.
+func (e *Environment) mainModuleForDir(dir string) (mainModule, bool) {
+	var found mainModule
+	var ok bool
+	for _, m := range e.mainModules {
+		rel, err := filepath.Rel(m.dir, dir)
+		if err != nil {
+			continue
+		}
+		if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if ok && len(m.dir) < len(found.dir) {
+			continue
+		}
+		found = m
+		ok = true
+	}
+	return found, ok
+}
+
+// Paths returns the resolved paths that can be used in the environment.
+func (e *Environment) Paths() []string {
+	return e.paths
+}
+
+// TranslateArgs returns a copy of args where each argument equal to a path passed to NewEnvironment
+// is replaced with the corresponding path of Paths.
+// The other arguments are not changed.
+//
+// This is useful to run a tool taking package paths, like 'dlv debug ./cmd/foo' or 'staticcheck ./...', in the environment.
+func (e *Environment) TranslateArgs(args []string) []string {
+	newArgs := slices.Clone(args)
+	for i, arg := range newArgs {
+		if j := slices.Index(e.origPaths, arg); j >= 0 {
+			newArgs[i] = e.paths[j]
+		}
+	}
+	return newArgs
+}
+
+// Command returns an *exec.Cmd to run the named program with the arguments in the environment, like exec.Command.
+//
+// The command runs in WorkingDir with the current environment variables and Env,
+// so go commands invoked by the program, like 'go generate' or a debugger, use the replaced files.
+// The arguments are used as they are. Use TranslateArgs to translate package paths in the arguments.
+func (e *Environment) Command(name string, args ...string) *exec.Cmd {
+	return e.commandContext(context.Background(), name, args...)
+}
+
+// commandContext is like Command but with the context to kill the command.
+func (e *Environment) commandContext(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = e.workingDir
+	if env := e.Env(); len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	return cmd
+}
+
+// PathModules returns the modules providing the paths.
+// The i-th module corresponds to the i-th path of Paths.
+//
+// The version is empty for a main module.
+// The module is the zero value for a pattern not in main modules like 'all' or 'golang.org/x/sync/...',
+// a standard library package, or a path outside modules.
+func (e *Environment) PathModules() []module.Version {
+	return e.pathModules
+}
 
-		stat, err := os.Stat(filepath.Join(modPaths[r.Mod], filepath.FromSlash(r.Path)))
+// ToolCommand returns an *exec.Cmd to run the tool with the arguments like 'go tool', with the replaced files.
+//
+// name is a tool name like 'stringer' or a package path of a tool directive like 'golang.org/x/tools/cmd/stringer'.
+// The tool directives in the current go.mod are carried to the environment in LayoutModule and LayoutModFile,
+// and the tool directives of all the main modules are available in LayoutWorkspace.
+// Options.Tools can add tools.
+//
+// ToolCommand builds the tool in the environment.
+// Unlike Command, the returned command runs in the current directory,
+// so that a code generator invoked by 'go generate' processes the current package.
+// The environment variables include Env.
+func (e *Environment) ToolCommand(name string, args ...string) (*exec.Cmd, error) {
+	// 'go tool -n' builds the tool and prints the path of the executable.
+	out, err := e.runGo("tool", "-n", name)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(strings.TrimSpace(string(out)), args...)
+	if env := e.Env(); len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	return cmd, nil
+}
+
+// Close removes the environment directory.
+func (e *Environment) Close() error {
+	return os.RemoveAll(e.dir)
+}
+
+// Update updates the replaced files in the environment.
+//
+// replaces replaces the whole set of the replaced files.
+// The items are applied in order.
+// A file that was replaced or removed by the previous replaces but is not by the new replaces is restored to the original content.
+//
+// Multiple items targeting the same file are reported as a *ConflictError.
+// Use MergeLayers to override items deliberately.
+func (e *Environment) Update(replaces []ReplaceItem) error {
+	items, err := e.resolveReplaceItems(replaces)
+	if err != nil {
+		return err
+	}
+	// Resolve the modules and filter the items by the version constraints.
+	// A new module is copied to the environment later, only when it has matched items and the changes are computed successfully.
+	newModules := map[string]*envModule{}
+	moduleFor := func(modulePath string) *envModule {
+		if m, ok := e.modules[modulePath]; ok {
+			return m
+		}
+		return newModules[modulePath]
+	}
+	var matched []ReplaceItem
+	unmatched := map[string]struct{}{}
+	for _, r := range items {
+		m := moduleFor(r.Mod)
+		if m == nil {
+			version, origDir, err := e.resolveModule(r.Mod)
+			if err != nil {
+				return err
+			}
+			dir, err := copiedModuleDir(r.Mod, version)
+			if err != nil {
+				return err
+			}
+			m = &envModule{
+				version: version,
+				origDir: origDir,
+				dir:     filepath.Join(e.dir, filepath.FromSlash(dir)),
+			}
+			newModules[r.Mod] = m
+		}
+		if r.Version != "" {
+			c, err := parseVersionConstraint(r.Version)
+			if err != nil {
+				return err
+			}
+			if !c.match(m.version) {
+				unmatched[r.Mod] = struct{}{}
+				continue
+			}
+		}
+		matched = append(matched, r)
+	}
+	for _, r := range matched {
+		delete(unmatched, r.Mod)
+	}
+	for _, r := range items {
+		if _, ok := unmatched[r.Mod]; !ok {
+			continue
+		}
+		version := moduleFor(r.Mod).version
+		if version == "" {
+			version = "(devel)"
+		}
+		return fmt.Errorf("uwagaki: no ReplaceItems for %s match the version %s", r.Mod, version)
+	}
+
+	// Items specified by Pkg or with different versions might target the same files.
+	if err := checkConflicts(0, withoutVersions(matched)); err != nil {
+		return err
+	}
+
+	// Compute the changes of the files first, as an item might depend on the results of the previous items.
+	files := map[string]*moduleFiles{}
+	for _, r := range matched {
+		m := moduleFor(r.Mod)
+		mf, ok := files[r.Mod]
+		if !ok {
+			mf = newModuleFiles(m.origDir)
+			files[r.Mod] = mf
+		}
+
+		if r.Pattern != "" {
+			names, err := mf.match(r.Pattern)
+			if err != nil {
+				return err
+			}
+			if len(names) == 0 {
+				return fmt.Errorf("uwagaki: ReplaceItem.Pattern matches no files: %s: %s", r.Mod, r.Pattern)
+			}
+			for _, name := range names {
+				if err := mf.apply(&r, name); err != nil {
+					return fmt.Errorf("uwagaki: failed to apply ReplaceItem to %s: %s: %w", r.Mod, name, err)
+				}
+			}
+			continue
+		}
+
+		stat, err := os.Stat(filepath.Join(m.origDir, filepath.FromSlash(r.Path)))
 		if err == nil {
 			if stat.IsDir() {
-				return "", nil, fmt.Errorf("uwagaki: ReplaceItem.Path must be a file: %s", r.Path)
+				return fmt.Errorf("uwagaki: ReplaceItem.Path must be a file: %s", r.Path)
 			}
 		} else if !errors.Is(err, os.ErrNotExist) {
-			return "", nil, err
+			return err
 		}
-
-		dst := filepath.Join(replacedModDir, filepath.FromSlash(r.Mod), filepath.FromSlash(r.Path))
-		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
-			return "", nil, err
+		if err := checkDrift(&r, m, e.onDrift); err != nil {
+			return err
+		}
+		if err := mf.apply(&r, r.Path); err != nil {
+			return fmt.Errorf("uwagaki: failed to apply ReplaceItem to %s: %s: %w", r.Mod, r.Path, err)
 		}
-		// Remove the file once if exists. The file is a hard link and the orignal file must not be affected.
-		if err := os.Remove(dst); err != nil && !errors.Is(err, os.ErrNotExist) {
-			return "", nil, err
+	}
+
+	// Copy the new modules with matched items.
+	for _, mod := range slices.Sorted(maps.Keys(files)) {
+		m, ok := newModules[mod]
+		if !ok {
+			continue
 		}
-		if err := os.WriteFile(dst, r.Content, 0644); err != nil {
-			return "", nil, err
+		if err := e.replace(mod, m); err != nil {
+			return err
 		}
+		e.modules[mod] = m
 	}
 
-	if len(paths) == 0 {
-		paths = []string{"."}
+	replaced := map[replacedFile]struct{}{}
+	for mod, mf := range files {
+		m := e.modules[mod]
+		for name, c := range mf.changes {
+			dst := filepath.Join(m.dir, filepath.FromSlash(name))
+			// Remove the file once if exists. The file is a hard link and the orignal file must not be affected.
+			if err := os.Remove(dst); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+			replaced[replacedFile{mod: mod, path: name}] = struct{}{}
+			if c.deleted {
+				continue
+			}
+			if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+				return err
+			}
+			if err := os.WriteFile(dst, c.content, 0644); err != nil {
+				return err
+			}
+		}
 	}
 
-	newPaths = make([]string, len(paths))
-	for i, pkg := range paths {
-		if !modfile.IsDirectoryPath(pkg) {
-			newPaths[i] = pkg
+	// Restore the files that are no longer replaced.
+	for f := range e.replaced {
+		if _, ok := replaced[f]; ok {
 			continue
 		}
+		m := e.modules[f.mod]
+		if err := restore(m.origDir, m.dir, f.path); err != nil {
+			return err
+		}
+	}
+
+	e.replaces = slices.Clone(replaces)
+	e.replaced = replaced
+	return e.writeMetadata()
+}
 
-		abs, err := filepath.Abs(pkg)
+// resolveReplaceItems validates replaces, and returns a copy of replaces where items with Pkg are resolved to items with Mod.
+func (e *Environment) resolveReplaceItems(replaces []ReplaceItem) ([]ReplaceItem, error) {
+	isMainModule := func(modulePath string) bool {
+		_, ok := e.mainModule(modulePath)
+		return ok
+	}
+
+	items := slices.Clone(replaces)
+	for i, r := range items {
+		if err := validateReplaceItem(&r, isMainModule); err != nil {
+			return nil, err
+		}
+		if r.Pkg == "" {
+			continue
+		}
+		m, err := e.resolvePackageModule(r.Pkg)
 		if err != nil {
-			return "", nil, err
+			return nil, err
+		}
+		if m.Path == "" {
+			return nil, fmt.Errorf("uwagaki: no module provides package %s", r.Pkg)
+		}
+		// The package directory in the module is the rest of the import path.
+		items[i].Mod = m.Path
+		items[i].Pkg = ""
+		pkgDir := strings.TrimPrefix(strings.TrimPrefix(r.Pkg, m.Path), "/")
+		if r.Pattern != "" {
+			items[i].Pattern = path.Join(pkgDir, r.Pattern)
+		} else {
+			items[i].Path = path.Join(pkgDir, r.Path)
 		}
+	}
+	return items, nil
+}
+
+// environmentModuleName returns a module name derived from the hash of the inputs.
+// The options affecting the environment's contents are also hashed.
+func environmentModuleName(goMod []byte, paths []string, replaces []ReplaceItem, options *Options) string {
+	h := sha256.New()
+	// Write the length before each value to avoid ambiguity.
+	writeLen := func(n int) {
+		_ = binary.Write(h, binary.LittleEndian, uint64(n))
+	}
+	write := func(b []byte) {
+		writeLen(len(b))
+		_, _ = h.Write(b)
+	}
+	write(goMod)
+	writeLen(len(paths))
+	for _, p := range paths {
+		write([]byte(p))
+	}
+	writeLen(len(replaces))
+	for _, r := range replaces {
+		write([]byte(r.Mod))
+		write([]byte(r.Pkg))
+		write([]byte(r.Path))
+		write([]byte(r.Pattern))
+		write([]byte(r.Version))
+		write([]byte(r.BaseHash))
+		write(r.Content)
+		if r.Delete {
+			writeLen(1)
+		} else {
+			writeLen(0)
+		}
+	}
+	writeLen(int(options.Layout))
+	writeLen(len(options.Modules))
+	for _, m := range options.Modules {
+		write([]byte(m))
+	}
+	writeLen(len(options.Require))
+	for _, m := range options.Require {
+		write([]byte(m.Path))
+		write([]byte(m.Version))
+	}
+	writeLen(len(options.Tools))
+	for _, tool := range options.Tools {
+		write([]byte(tool))
+	}
+	return "uwagaki_" + hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// patchedModulesSymbol is the symbol name for the linker's -X flag to record patched modules.
+// The symbol doesn't have to exist, as the flag is used only to record the patched modules in the build information.
+const patchedModulesSymbol = "github.com/hajimehoshi/uwagaki.patchedModules"
 
-		if currentGoMod == "" {
-			newPaths[i] = abs
+// PatchedModules returns the modules patched by an environment, from the build information of a binary built with Environment.Env.
+//
+// A returned module is the dependency in the build information if exists, which has the replacement in the environment.
+// This is useful to report patched modules from a binary built in an environment, especially with LayoutWorkspace.
+//
+// PatchedModules returns nil if the binary was not built in an environment,
+// or the -ldflags setting is not recorded, e.g. with -trimpath or with -ldflags in the command line.
+func PatchedModules(info *debug.BuildInfo) []*debug.Module {
+	var value string
+	for _, s := range info.Settings {
+		if s.Key != "-ldflags" {
 			continue
 		}
+		for _, f := range strings.Fields(s.Value) {
+			if v, ok := strings.CutPrefix(f, "-X="+patchedModulesSymbol+"="); ok {
+				value = v
+			}
+		}
+	}
+	if value == "" {
+		return nil
+	}
+
+	var mods []*debug.Module
+	for _, m := range strings.Split(value, ",") {
+		modulePath, version, _ := strings.Cut(m, "@")
+		i := slices.IndexFunc(info.Deps, func(dep *debug.Module) bool {
+			return dep.Path == modulePath
+		})
+		if i >= 0 {
+			mods = append(mods, info.Deps[i])
+			continue
+		}
+		mods = append(mods, &debug.Module{
+			Path:    modulePath,
+			Version: version,
+		})
+	}
+	return mods
+}
+
+func (e *Environment) goCommand(args ...string) *exec.Cmd {
+	return e.Command("go", args...)
+}
 
-		rel, err := filepath.Rel(filepath.Dir(currentGoMod), abs)
+func (e *Environment) runGo(args ...string) ([]byte, error) {
+	var buf bytes.Buffer
+	cmd := e.goCommand(args...)
+	cmd.Stderr = &buf
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("uwagaki: '%s' failed: %w\n%s", strings.Join(cmd.Args, " "), err, buf.String())
+	}
+	return out, nil
+}
+
+// goEnv returns the value of the go environment variable.
+// goEnv returns an empty string if 'go env' fails.
+func goEnv(key string) string {
+	out, err := exec.Command("go", "env", key).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// copyGoSum copies go.sum next to goMod to dst if exists.
+func copyGoSum(goMod string, dst string) error {
+	goSum := strings.TrimSuffix(goMod, ".mod") + ".sum"
+	content, err := os.ReadFile(goSum)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dst, content, 0644)
+}
+
+// restore restores the file at the slash-separated path in dstModDir to the original file in srcModDir.
+// If the original file doesn't exist, the file is removed.
+func restore(srcModDir, dstModDir string, path string) error {
+	dst := filepath.Join(dstModDir, filepath.FromSlash(path))
+	if err := os.Remove(dst); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	content, err := os.ReadFile(filepath.Join(srcModDir, filepath.FromSlash(path)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dst, content, 0644)
+}
+
+// checkSelectedVersion checks that the version of the module selected in the environment is the requested version.
+// query is a version or a version query like 'latest'.
+//
+// 'go get' in the environment cannot select a version lower than a main module requires,
+// e.g. the current module in LayoutModule or the main modules in LayoutWorkspace, and doesn't report it.
+func (e *Environment) checkSelectedVersion(modulePath string, query string) error {
+	want := query
+	if semver.Canonical(query) != query {
+		out, err := e.runGo("list", "-m", "-f", "{{.Version}}", modulePath+"@"+query)
 		if err != nil {
-			return "", nil, err
+			return err
 		}
-		newPaths[i] = path.Join(currentModPath, filepath.ToSlash(rel))
+		want = strings.TrimSpace(string(out))
 	}
+	out, err := e.runGo("list", "-m", "-f", "{{.Version}}", modulePath)
+	if err != nil {
+		return err
+	}
+	if got := strings.TrimSpace(string(out)); got != want {
+		return fmt.Errorf("uwagaki: %s@%s is requested but %s is selected; a main module might require a higher version", modulePath, want, got)
+	}
+	return nil
+}
 
-	// Run go mod downlaod
-	{
-		var buf bytes.Buffer
-		cmd := exec.Command("go", "mod", "download")
-		cmd.Stderr = &buf
-		cmd.Dir = work
-		if err := cmd.Run(); err != nil {
-			return "", nil, fmt.Errorf("uwagaki: '%s' failed: %w\n%s", strings.Join(cmd.Args, " "), err, buf.String())
+// resolveModule returns the version and the directory of the module in the environment.
+//
+// If the module is already in the build list, the selected version is used so that the dependency versions are kept.
+// Otherwise, the module is added by 'go get'.
+func (e *Environment) resolveModule(modulePath string) (version string, dir string, err error) {
+	out, err := e.runGo("list", "-m", "-f", "{{.Version}}\t{{.Dir}}", modulePath)
+	if err != nil {
+		// The module is not in the build list.
+		if _, err := e.runGo("get", modulePath+"/..."); err != nil {
+			return "", "", err
+		}
+		out, err = e.runGo("list", "-m", "-f", "{{.Version}}\t{{.Dir}}", modulePath)
+		if err != nil {
+			return "", "", err
 		}
 	}
+	version, dir, _ = strings.Cut(strings.TrimSuffix(string(out), "\n"), "\t")
+	if dir != "" {
+		return version, dir, nil
+	}
 
-	return work, newPaths, nil
+	// The module is not downloaded yet.
+	out, err = e.runGo("mod", "download", "-json", modulePath+"@"+version)
+	if err != nil {
+		return "", "", err
+	}
+	var m struct {
+		Dir string
+	}
+	if err := json.Unmarshal(out, &m); err != nil {
+		return "", "", err
+	}
+	return version, m.Dir, nil
 }
 
-func replace(work string, replacedFilesDir string, modulePath string, moduleSrcFilepath string) error {
+// translatePath translates a path passed to NewEnvironment to a path that can be used in the environment.
+//
+// For a local directory, a local file, or a pattern with '...' in a main module, translatePath returns the main module as m.
+// For an import path, translatePath returns true as resolve, and the module should be resolved by resolvePackageModule.
+func (e *Environment) translatePath(pkg string) (newPath string, m module.Version, resolve bool, err error) {
+	switch {
+	case pkg == "all":
+		// The environment's main module has no packages, so 'all' doesn't make sense.
+		if e.layout == LayoutModule {
+			return "", module.Version{}, false, fmt.Errorf("uwagaki: the pattern 'all' is not available with LayoutModule; use LayoutWorkspace or LayoutModFile instead")
+		}
+		return pkg, module.Version{}, false, nil
+	case pkg == "std" || pkg == "cmd":
+		return pkg, module.Version{}, false, nil
+	case strings.HasSuffix(pkg, ".go"):
+		// A Go file. This is the same rule as go commands.
+	case !modfile.IsDirectoryPath(pkg):
+		// An import path or a pattern. The version is already pinned in the environment.
+		pkg, _, _ = strings.Cut(pkg, "@")
+		return pkg, module.Version{}, !strings.Contains(pkg, "..."), nil
+	}
+
+	abs, err := filepath.Abs(pkg)
+	if err != nil {
+		return "", module.Version{}, false, err
+	}
+
+	if len(e.mainModules) == 0 {
+		return abs, module.Version{}, false, nil
+	}
+
+	dir := abs
+	if strings.HasSuffix(pkg, ".go") {
+		dir = filepath.Dir(abs)
+	}
+	mm, ok := e.mainModuleForDir(dir)
+	if !ok {
+		return "", module.Version{}, false, fmt.Errorf("uwagaki: %s is outside the main modules", pkg)
+	}
+	m = module.Version{Path: mm.path}
+
+	// Go commands run in the current directory, so the paths don't have to be changed.
+	if e.layout == LayoutModFile {
+		return pkg, m, false, nil
+	}
+
+	// Go commands run in the environment directory, so an absolute path is used for a file.
+	if strings.HasSuffix(pkg, ".go") {
+		return abs, m, false, nil
+	}
+
+	rel, err := filepath.Rel(mm.dir, abs)
+	if err != nil {
+		return "", module.Version{}, false, err
+	}
+	return path.Join(mm.path, filepath.ToSlash(rel)), m, false, nil
+}
+
+// resolvePackageModule returns the module providing the package in the environment.
+//
+// If the package doesn't exist, e.g. the package is added by replacements, the module in the build list whose path is the longest prefix of the package path is used.
+// If no module in the environment provides the package, the module is added by 'go get'.
+func (e *Environment) resolvePackageModule(pkg string) (module.Version, error) {
+	resolve := func() (m module.Version, std bool, err error) {
+		out, err := e.runGo("list", "-e", "-f", "{{.Standard}}\t{{with .Module}}{{.Path}}\t{{.Version}}{{end}}", pkg)
+		if err != nil {
+			return module.Version{}, false, err
+		}
+		tokens := strings.Split(strings.TrimSuffix(string(out), "\n"), "\t")
+		if tokens[0] == "true" {
+			return module.Version{}, true, nil
+		}
+		if len(tokens) == 3 {
+			return module.Version{Path: tokens[1], Version: tokens[2]}, false, nil
+		}
+
+		// The package doesn't exist. Find the module from the build list.
+		out, err = e.runGo("list", "-m", "-e", "-f", "{{.Path}}\t{{.Version}}", "all")
+		if err != nil {
+			return module.Version{}, false, err
+		}
+		for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+			path, version, _ := strings.Cut(line, "\t")
+			if pkg != path && !strings.HasPrefix(pkg, path+"/") {
+				continue
+			}
+			if len(path) > len(m.Path) {
+				m = module.Version{Path: path, Version: version}
+			}
+		}
+		return m, false, nil
+	}
+
+	m, std, err := resolve()
+	if err != nil {
+		return module.Version{}, err
+	}
+	if std {
+		return module.Version{}, nil
+	}
+	if m.Path == "" {
+		if _, err := e.runGo("get", pkg); err != nil {
+			return module.Version{}, err
+		}
+		m, _, err = resolve()
+		if err != nil {
+			return module.Version{}, err
+		}
+		if m.Path == "" {
+			return module.Version{}, fmt.Errorf("uwagaki: no module provides package %s", pkg)
+		}
+	}
+
+	// The version of a main module is a dummy or empty.
+	if _, ok := e.mainModule(m.Path); ok {
+		m.Version = ""
+	}
+	return m, nil
+}
+
+// copiedModuleDir returns the slash-separated directory path of a copied module relative to the environment directory.
+//
+// The directory name includes the version with '+', which cannot be used in module paths,
+// so that directories of nested module paths (e.g. golang.org/x/tools and golang.org/x/tools/gopls) never overlap.
+// '@' is not used unlike the module cache, as go commands like 'go mod edit -replace' treat '@' as a version separator.
+// Upper-case letters are escaped in the same way as the module cache, so that paths never collide on case-insensitive file systems.
+func copiedModuleDir(modulePath string, version string) (string, error) {
+	if version == "" {
+		version = "devel"
+	}
+	escapedVersion, err := module.EscapeVersion(version)
+	if err != nil {
+		return "", err
+	}
+	escapedPath, err := module.EscapePath(modulePath)
+	if err != nil {
+		// A main module path is not always a valid module path for module.EscapePath, e.g. without a dot in the first element.
+		// Escape each element instead, as each element of a valid import path is a valid file name.
+		if err := module.CheckImportPath(modulePath); err != nil {
+			return "", err
+		}
+		elems := strings.Split(modulePath, "/")
+		for i, elem := range elems {
+			e, err := module.EscapeVersion(elem)
+			if err != nil {
+				return "", err
+			}
+			elems[i] = e
+		}
+		escapedPath = strings.Join(elems, "/")
+	}
+	return "mod/" + escapedPath + "+" + escapedVersion, nil
+}
+
+func (e *Environment) replace(modulePath string, m *envModule) error {
+	if _, ok := e.mainModule(modulePath); ok && e.layout == LayoutModFile {
+		return fmt.Errorf("uwagaki: the current module %s cannot be replaced with LayoutModFile", modulePath)
+	}
+
 	// Copy files.
-	dst := filepath.Join(replacedFilesDir, filepath.FromSlash(modulePath))
+	moduleSrcFilepath := m.origDir
+	dst := m.dir
 	f, err := os.Stat(dst)
 	if err != nil && !errors.Is(err, os.ErrNotExist) {
 		return err
@@ -300,16 +1468,28 @@ func replace(work string, replacedFilesDir string, modulePath string, moduleSrcF
 		}
 	}
 
-	// go mod edit
+	// go mod edit or go work edit
 	{
-		dstRel := "." + string(filepath.Separator) + filepath.Join("mod", filepath.FromSlash(modulePath))
-		var buf bytes.Buffer
+		rel, err := filepath.Rel(e.dir, dst)
+		if err != nil {
+			return err
+		}
+		dstRel := "." + string(filepath.Separator) + rel
+		args := []string{"mod", "edit", "-replace", modulePath + "=" + dstRel}
+		switch e.layout {
+		case LayoutModFile:
+			// Relative paths in the alternate go.mod are relative to the current module's directory.
+			args = []string{"mod", "edit", "-replace", modulePath + "=" + dst, e.ModFile()}
+		case LayoutWorkspace:
+			args = []string{"work", "edit", "-replace", modulePath + "=" + dstRel}
+			// A main module cannot be replaced in go.work. Use the copied module instead.
+			if m, ok := e.mainModule(modulePath); ok {
+				args = []string{"work", "edit", "-dropuse", m.dir, "-use", dstRel}
+			}
+		}
 		// TODO: What if the file path includes a space?
-		cmd := exec.Command("go", "mod", "edit", "-replace", modulePath+"="+dstRel)
-		cmd.Stderr = &buf
-		cmd.Dir = work
-		if err := cmd.Run(); err != nil {
-			return fmt.Errorf("uwagaki: '%s' failed: %w\n%s", strings.Join(cmd.Args, " "), err, buf.String())
+		if _, err := e.runGo(args...); err != nil {
+			return err
 		}
 	}
 
@@ -5,14 +5,22 @@ package uwagaki_test
 
 import (
 	"bytes"
+	"debug/buildinfo"
+	"errors"
+	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"slices"
 	"strings"
+	"sync"
 	"testing"
 
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+
 	"github.com/hajimehoshi/uwagaki"
 )
 
@@ -201,7 +209,7 @@ func Foo() {
 							Content: mustReadFile("./testdata/language/additional_file_by_uwagaki.go"),
 						},
 					},
-					expectedPaths:   []string{"golang.org/x/text/language@v0.22.0"},
+					expectedPaths:   []string{"golang.org/x/text/language"},
 					temporaryMainGo: mustReadFile("./testdata/overwrite_external/main.go"),
 					expectedOutput:  "Hello, Uwagaki (language)!",
 				},
@@ -216,7 +224,7 @@ func Foo() {
 							Content: mustReadFile("./testdata/language/additional_file_by_uwagaki.go"),
 						},
 					},
-					expectedPaths:   []string{"golang.org/x/text/language@v0.22.0"},
+					expectedPaths:   []string{"golang.org/x/text/language"},
 					temporaryMainGo: mustReadFile("./testdata/overwrite_external/main.go"),
 					expectedOutput:  "Hello, Uwagaki (language)!",
 				},
@@ -418,3 +426,1207 @@ func Foo() {
 		})
 	}
 }
+
+func readModulePath(t *testing.T, dir string) string {
+	content, err := os.ReadFile(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return modfile.ModulePath(content)
+}
+
+func TestEnvironmentModuleName(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	// Create environments concurrently with the same inputs.
+	const n = 4
+	envs := make([]*uwagaki.Environment, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			envs[i], errs[i] = uwagaki.NewEnvironment([]string{"."}, nil, nil)
+		}()
+	}
+	wg.Wait()
+	for i := range n {
+		if errs[i] != nil {
+			t.Fatal(errs[i])
+		}
+		defer envs[i].Close()
+	}
+
+	dirs := map[string]struct{}{}
+	for _, env := range envs {
+		dirs[env.Dir()] = struct{}{}
+		if got, want := readModulePath(t, env.Dir()), readModulePath(t, envs[0].Dir()); got != want {
+			t.Errorf("module name: got: %s, want: %s", got, want)
+		}
+	}
+	if got, want := len(dirs), n; got != want {
+		t.Errorf("the number of directories: got: %d, want: %d", got, want)
+	}
+
+	// Different inputs result in a different module name.
+	env, err := uwagaki.NewEnvironment([]string{"./foo"}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer env.Close()
+	if got, notWant := readModulePath(t, env.Dir()), readModulePath(t, envs[0].Dir()); got == notWant {
+		t.Errorf("module name: got: %s, want: not %s", got, notWant)
+	}
+
+}
+
+func TestEnvironmentModuleNameOptions(t *testing.T) {
+	// -mod=mod is not available in the workspace mode.
+	t.Setenv("GOFLAGS", "")
+
+	dir := createSyncModule(t, "example.com/name")
+	writeFiles(t, dir, map[string]string{
+		"cmd/hello/main.go": string(mustReadFile("./testdata/stringer/main.go")),
+	})
+	t.Chdir(dir)
+
+	// Different options result in different module names.
+	names := map[string]struct{}{}
+	for _, options := range []*uwagaki.Options{
+		nil,
+		{Layout: uwagaki.LayoutWorkspace},
+		{Layout: uwagaki.LayoutWorkspace, Require: []module.Version{{Path: "golang.org/x/sync", Version: "v0.23.0"}}},
+		{Layout: uwagaki.LayoutWorkspace, Tools: []string{"example.com/name/cmd/hello"}},
+	} {
+		env, err := uwagaki.NewEnvironment([]string{"."}, nil, options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer env.Close()
+		name := readModulePath(t, env.Dir())
+		if _, ok := names[name]; ok {
+			t.Errorf("module name with %+v: got: %s, want: a different name", options, name)
+		}
+		names[name] = struct{}{}
+	}
+}
+
+func TestEnvironmentOptions(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	dir := filepath.Join(t.TempDir(), "env")
+	env, err := uwagaki.NewEnvironment([]string{"."}, nil, &uwagaki.Options{
+		ModuleName: "example.com/env",
+		Dir:        dir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer env.Close()
+
+	if got, want := env.Dir(), dir; got != want {
+		t.Errorf("dir: got: %s, want: %s", got, want)
+	}
+	if got, want := readModulePath(t, env.Dir()), "example.com/env"; got != want {
+		t.Errorf("module name: got: %s, want: %s", got, want)
+	}
+
+	// The same directory cannot be used twice.
+	if _, err := uwagaki.NewEnvironment([]string{"."}, nil, &uwagaki.Options{Dir: dir}); err == nil {
+		t.Errorf("NewEnvironment with an existing directory must fail")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "go.mod")); err != nil {
+		t.Errorf("the existing environment must not be affected: %v", err)
+	}
+}
+
+func TestLayoutWorkspace(t *testing.T) {
+	// -mod=mod is not available in the workspace mode, but Env overrides it.
+	t.Setenv("GOFLAGS", "-mod=mod")
+
+	dir := createSyncModule(t, "example.com/workspace")
+	content := mustReadFile("./testdata/sync/additional_file_by_uwagaki.go")
+	t.Chdir(dir)
+
+	env, err := uwagaki.NewEnvironment([]string{"."}, []uwagaki.ReplaceItem{
+		{
+			Mod:     "golang.org/x/sync",
+			Path:    "additional_file_by_uwagaki.go",
+			Content: content,
+		},
+	}, &uwagaki.Options{
+		Layout: uwagaki.LayoutWorkspace,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer env.Close()
+
+	if got, want := env.Paths(), []string{"example.com/workspace"}; !slices.Equal(got, want) {
+		t.Errorf("paths: got: %v, want: %v", got, want)
+	}
+
+	bin := filepath.Join(t.TempDir(), "main")
+	cmd := env.Command("go", append([]string{"build", "-o", bin}, env.Paths()...)...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+
+	out, err := exec.Command(bin).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.TrimSpace(string(out)), "Hello, Uwagaki (sync)!"; got != want {
+		t.Errorf("output: got: %s, want: %s", got, want)
+	}
+
+	info, err := buildinfo.ReadFile(bin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := info.Main.Path, "example.com/workspace"; got != want {
+		t.Errorf("main module: got: %s, want: %s", got, want)
+	}
+	mods := uwagaki.PatchedModules(info)
+	if len(mods) != 1 {
+		t.Fatalf("len(PatchedModules()): got: %d, want: 1", len(mods))
+	}
+	if got, want := mods[0].Path+"@"+mods[0].Version, "golang.org/x/sync@v0.11.0"; got != want {
+		t.Errorf("patched module: got: %s, want: %s", got, want)
+	}
+	if mods[0].Replace == nil {
+		t.Errorf("patched module must have a replacement")
+	}
+
+	// Without Env, the patched modules are not recorded even though the module is replaced.
+	cmd = exec.Command("go", "build", "-o", bin)
+	cmd.Args = append(cmd.Args, env.Paths()...)
+	cmd.Dir = env.Dir()
+	cmd.Env = append(os.Environ(), "GOFLAGS=")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	info, err = buildinfo.ReadFile(bin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := uwagaki.PatchedModules(info); got != nil {
+		t.Errorf("PatchedModules(): got: %v, want: nil", got)
+	}
+}
+
+func TestLayoutWorkspaceGoEnvFile(t *testing.T) {
+	// GOFLAGS is set by 'go env -w' instead of the environment variable.
+	t.Setenv("GOFLAGS", "")
+	goEnv := filepath.Join(t.TempDir(), "env")
+	if err := os.WriteFile(goEnv, []byte("GOFLAGS=-mod=mod -tags=uwagakitest\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GOENV", goEnv)
+
+	dir := createSyncModule(t, "example.com/workspacegoenv")
+	content := mustReadFile("./testdata/sync/additional_file_by_uwagaki.go")
+	t.Chdir(dir)
+
+	env, err := uwagaki.NewEnvironment([]string{"."}, []uwagaki.ReplaceItem{
+		{
+			Mod:     "golang.org/x/sync",
+			Path:    "additional_file_by_uwagaki.go",
+			Content: content,
+		},
+	}, &uwagaki.Options{
+		Layout: uwagaki.LayoutWorkspace,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer env.Close()
+
+	// The -mod flag is removed, while the other flags are kept.
+	var flags []string
+	for _, kv := range env.Env() {
+		if v, ok := strings.CutPrefix(kv, "GOFLAGS="); ok {
+			flags = strings.Fields(v)
+		}
+	}
+	if !slices.Contains(flags, "-tags=uwagakitest") || slices.Contains(flags, "-mod=mod") {
+		t.Errorf("GOFLAGS: got: %q, want: -tags=uwagakitest without -mod=mod", flags)
+	}
+
+	if out, err := env.Command("go", append([]string{"build", "-o", filepath.Join(t.TempDir(), "main")}, env.Paths()...)...).CombinedOutput(); err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+}
+
+func TestLayoutWorkspaceRequire(t *testing.T) {
+	t.Setenv("GOFLAGS", "")
+
+	// The main module requires golang.org/x/sync v0.11.0.
+	dir := createSyncModule(t, "example.com/workspacerequire")
+	t.Chdir(dir)
+
+	env, err := uwagaki.NewEnvironment([]string{"."}, nil, &uwagaki.Options{
+		Layout:  uwagaki.LayoutWorkspace,
+		Require: []module.Version{{Path: "golang.org/x/sync", Version: "v0.23.0"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer env.Close()
+
+	out, err := env.Command("go", "list", "-m", "-f", "{{.Version}}", "golang.org/x/sync").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.TrimSpace(string(out)), "v0.23.0"; got != want {
+		t.Errorf("version: got: %s, want: %s", got, want)
+	}
+
+	// A version lower than the main module requires cannot be selected.
+	if _, err := uwagaki.NewEnvironment([]string{"."}, nil, &uwagaki.Options{
+		Layout:  uwagaki.LayoutWorkspace,
+		Require: []module.Version{{Path: "golang.org/x/sync", Version: "v0.10.0"}},
+	}); err == nil {
+		t.Errorf("NewEnvironment with a version lower than the main module requires must fail")
+	}
+}
+
+func TestLayoutModFile(t *testing.T) {
+	dir := createSyncModule(t, "example.com/modfile")
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte(`package main
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sync"
+)
+
+func main() {
+	sync.AdditionalFuncByUwagaki()
+	// Read a file relative to the current directory.
+	b, err := os.ReadFile("data.txt")
+	if err != nil {
+		panic(err)
+	}
+	fmt.Print(string(b))
+}
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "data.txt"), []byte("Hello, data!\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	origGoMod := mustReadFile(filepath.Join(dir, "go.mod"))
+	content := mustReadFile("./testdata/sync/additional_file_by_uwagaki.go")
+	t.Chdir(dir)
+
+	env, err := uwagaki.NewEnvironment([]string{"."}, []uwagaki.ReplaceItem{
+		{
+			Mod:     "golang.org/x/sync",
+			Path:    "additional_file_by_uwagaki.go",
+			Content: content,
+		},
+	}, &uwagaki.Options{
+		Layout: uwagaki.LayoutModFile,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer env.Close()
+
+	if got, want := env.WorkingDir(), dir; got != want {
+		t.Errorf("working dir: got: %s, want: %s", got, want)
+	}
+	if got, want := env.Paths(), []string{"."}; !slices.Equal(got, want) {
+		t.Errorf("paths: got: %v, want: %v", got, want)
+	}
+
+	cmd := exec.Command("go", "run", "-modfile="+env.ModFile())
+	cmd.Args = append(cmd.Args, env.Paths()...)
+	cmd.Dir = env.WorkingDir()
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	if got, want := strings.TrimSpace(string(out)), "Hello, Uwagaki (sync)!\nHello, data!"; got != want {
+		t.Errorf("output: got: %q, want: %q", got, want)
+	}
+
+	// The same with Env.
+	cmd = exec.Command("go", "run")
+	cmd.Args = append(cmd.Args, env.Paths()...)
+	cmd.Dir = env.WorkingDir()
+	cmd.Env = append(os.Environ(), env.Env()...)
+	out, err = cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	if got, want := strings.TrimSpace(string(out)), "Hello, Uwagaki (sync)!\nHello, data!"; got != want {
+		t.Errorf("output: got: %q, want: %q", got, want)
+	}
+
+	if got, want := mustReadFile(filepath.Join(dir, "go.mod")), origGoMod; !bytes.Equal(got, want) {
+		t.Errorf("go.mod must not be changed: got: %s, want: %s", got, want)
+	}
+}
+
+func TestLayoutWorkspaceMultipleModules(t *testing.T) {
+	// -mod=mod is not available in the workspace mode.
+	t.Setenv("GOFLAGS", "")
+
+	// lib is a library module that calls the patched function.
+	lib := createSyncModule(t, "example.com/lib")
+	if err := os.Remove(filepath.Join(lib, "main.go")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(lib, "lib.go"), []byte(`package lib
+
+import "golang.org/x/sync"
+
+func Foo() {
+	sync.AdditionalFuncByUwagaki()
+}
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// app is the current module that uses lib without requiring it.
+	app := t.TempDir()
+	if err := os.WriteFile(filepath.Join(app, "go.mod"), []byte("module example.com/app\n\ngo 1.24.0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(app, "main.go"), []byte(`package main
+
+import "example.com/lib"
+
+func main() {
+	lib.Foo()
+}
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	content := mustReadFile("./testdata/sync/additional_file_by_uwagaki.go")
+	t.Chdir(app)
+
+	env, err := uwagaki.NewEnvironment([]string{"."}, []uwagaki.ReplaceItem{
+		{
+			Mod:     "golang.org/x/sync",
+			Path:    "additional_file_by_uwagaki.go",
+			Content: content,
+		},
+	}, &uwagaki.Options{
+		Layout:  uwagaki.LayoutWorkspace,
+		Modules: []string{lib},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer env.Close()
+
+	// Run the command in the current directory with GOWORK.
+	cmd := exec.Command("go", "run", ".")
+	cmd.Dir = app
+	cmd.Env = append(os.Environ(), env.Env()...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	if got, want := strings.TrimSpace(string(out)), "Hello, Uwagaki (sync)!"; got != want {
+		t.Errorf("output: got: %q, want: %q", got, want)
+	}
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestNestedModules(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"outer/go.mod": "module example.com/outer\n\ngo 1.24.0\n",
+		"outer/outer.go": `package outer
+
+import "fmt"
+
+func Outer() {
+	fmt.Println("Outer")
+}
+`,
+		"outer/inner/go.mod": "module example.com/outer/inner\n\ngo 1.24.0\n",
+		"outer/inner/inner.go": `package inner
+
+import "fmt"
+
+func Inner() {
+	fmt.Println("Inner")
+}
+`,
+		"app/go.mod": `module example.com/app
+
+go 1.24.0
+
+require (
+	example.com/outer v0.0.0
+	example.com/outer/inner v0.0.0
+)
+
+replace (
+	example.com/outer => ../outer
+	example.com/outer/inner => ../outer/inner
+)
+`,
+		"app/main.go": `package main
+
+import (
+	"example.com/outer"
+	"example.com/outer/inner"
+)
+
+func main() {
+	outer.Outer()
+	inner.Inner()
+}
+`,
+	})
+
+	outerItem := uwagaki.ReplaceItem{
+		Mod:  "example.com/outer",
+		Path: "outer.go",
+		Content: []byte(`package outer
+
+import "fmt"
+
+func Outer() {
+	fmt.Println("Patched Outer")
+}
+`),
+	}
+	innerItem := uwagaki.ReplaceItem{
+		Mod:  "example.com/outer/inner",
+		Path: "inner.go",
+		Content: []byte(`package inner
+
+import "fmt"
+
+func Inner() {
+	fmt.Println("Patched Inner")
+}
+`),
+	}
+
+	t.Chdir(filepath.Join(dir, "app"))
+
+	for _, tc := range []struct {
+		name  string
+		items []uwagaki.ReplaceItem
+	}{
+		{
+			name:  "outer first",
+			items: []uwagaki.ReplaceItem{outerItem, innerItem},
+		},
+		{
+			name:  "inner first",
+			items: []uwagaki.ReplaceItem{innerItem, outerItem},
+		},
+		{
+			name: "package paths",
+			items: []uwagaki.ReplaceItem{
+				{
+					Pkg:     "example.com/outer/inner",
+					Path:    innerItem.Path,
+					Content: innerItem.Content,
+				},
+				{
+					Pkg:     "example.com/outer",
+					Path:    outerItem.Path,
+					Content: outerItem.Content,
+				},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			env, err := uwagaki.NewEnvironment([]string{"."}, tc.items, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer env.Close()
+
+			cmd := exec.Command("go", "run")
+			cmd.Args = append(cmd.Args, env.Paths()...)
+			cmd.Dir = env.Dir()
+			out, err := cmd.CombinedOutput()
+			if err != nil {
+				t.Fatalf("%v\n%s", err, out)
+			}
+			if got, want := strings.TrimSpace(string(out)), "Patched Outer\nPatched Inner"; got != want {
+				t.Errorf("output: got: %q, want: %q", got, want)
+			}
+		})
+	}
+}
+
+func TestPathWithVersion(t *testing.T) {
+	content := mustReadFile("./testdata/sync/additional_file_by_uwagaki.go")
+	// The main module requires golang.org/x/sync v0.11.0.
+	workspaceDir := createSyncModule(t, "example.com/pathwithversion")
+	t.Chdir(t.TempDir())
+
+	env, err := uwagaki.NewEnvironment([]string{"golang.org/x/sync@v0.10.0"}, []uwagaki.ReplaceItem{
+		{
+			Mod:     "golang.org/x/sync",
+			Path:    "additional_file_by_uwagaki.go",
+			Content: content,
+		},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer env.Close()
+
+	if got, want := env.Paths(), []string{"golang.org/x/sync"}; !slices.Equal(got, want) {
+		t.Errorf("paths: got: %v, want: %v", got, want)
+	}
+
+	cmd := exec.Command("go", "list", "-m", "-f", "{{.Version}} {{.Replace.Path}}", "golang.org/x/sync")
+	cmd.Dir = env.Dir()
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	version, replace, _ := strings.Cut(strings.TrimSpace(string(out)), " ")
+	if got, want := version, "v0.10.0"; got != want {
+		t.Errorf("version: got: %s, want: %s", got, want)
+	}
+	if _, err := os.Stat(filepath.Join(env.Dir(), replace, "additional_file_by_uwagaki.go")); err != nil {
+		t.Errorf("the replaced file must exist: %v", err)
+	}
+
+	// In the workspace mode, a version lower than the main module requires cannot be pinned.
+	t.Run("workspace", func(t *testing.T) {
+		// -mod=mod is not available in the workspace mode.
+		t.Setenv("GOFLAGS", "")
+
+		t.Chdir(workspaceDir)
+
+		if _, err := uwagaki.NewEnvironment([]string{"golang.org/x/sync@v0.10.0"}, nil, &uwagaki.Options{
+			Layout: uwagaki.LayoutWorkspace,
+		}); err == nil {
+			t.Errorf("NewEnvironment with a version lower than the main module requires must fail")
+		}
+	})
+}
+
+func TestPathModules(t *testing.T) {
+	t.Run("without go.mod", func(t *testing.T) {
+		t.Chdir(t.TempDir())
+
+		// No module provides github.com/pkg/errors yet.
+		env, err := uwagaki.NewEnvironment([]string{"github.com/pkg/errors", "fmt"}, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer env.Close()
+
+		if got, want := env.PathModules(), []module.Version{{Path: "github.com/pkg/errors", Version: "v0.9.1"}, {}}; !slices.Equal(got, want) {
+			t.Errorf("modules: got: %v, want: %v", got, want)
+		}
+
+		cmd := exec.Command("go", "build", "github.com/pkg/errors")
+		cmd.Dir = env.Dir()
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Errorf("%v\n%s", err, out)
+		}
+	})
+
+	t.Run("with go.mod", func(t *testing.T) {
+		dir := createSyncModule(t, "example.com/pathmodules")
+		t.Chdir(dir)
+
+		env, err := uwagaki.NewEnvironment([]string{".", "golang.org/x/sync/errgroup", "./..."}, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer env.Close()
+
+		if got, want := env.PathModules(), []module.Version{{Path: "example.com/pathmodules"}, {Path: "golang.org/x/sync", Version: "v0.11.0"}, {Path: "example.com/pathmodules"}}; !slices.Equal(got, want) {
+			t.Errorf("modules: got: %v, want: %v", got, want)
+		}
+	})
+}
+
+func TestFilesAndPatterns(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod": "module example.com/m\n\ngo 1.24.0\n",
+		"cmd/x/main.go": `package main
+
+import "example.com/m/lib"
+
+func main() {
+	lib.Foo()
+}
+`,
+		"lib/lib.go": `package lib
+
+import "fmt"
+
+func Foo() {
+	fmt.Println("Foo is called")
+}
+`,
+	})
+	patch := uwagaki.ReplaceItem{
+		Mod:  "example.com/m",
+		Path: "lib/lib.go",
+		Content: []byte(`package lib
+
+import "fmt"
+
+func Foo() {
+	fmt.Println("Overwritten Foo is called")
+}
+`),
+	}
+	t.Chdir(dir)
+
+	env, err := uwagaki.NewEnvironment([]string{"./cmd/x/main.go", "./...", "./lib/..."}, []uwagaki.ReplaceItem{patch}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer env.Close()
+
+	if got, want := env.Paths(), []string{filepath.Join(dir, "cmd", "x", "main.go"), "example.com/m/...", "example.com/m/lib/..."}; !slices.Equal(got, want) {
+		t.Errorf("paths: got: %v, want: %v", got, want)
+	}
+
+	cmd := exec.Command("go", "run", env.Paths()[0])
+	cmd.Dir = env.Dir()
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	if got, want := strings.TrimSpace(string(out)), "Overwritten Foo is called"; got != want {
+		t.Errorf("output: got: %q, want: %q", got, want)
+	}
+
+	cmd = exec.Command("go", "list", env.Paths()[1])
+	cmd.Dir = env.Dir()
+	out, err = cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	if got, want := strings.TrimSpace(string(out)), "example.com/m/cmd/x\nexample.com/m/lib"; got != want {
+		t.Errorf("packages: got: %q, want: %q", got, want)
+	}
+
+	for _, p := range []string{"..", "../...", "all"} {
+		if _, err := uwagaki.NewEnvironment([]string{p}, nil, nil); err == nil {
+			t.Errorf("NewEnvironment with %q must fail", p)
+		}
+	}
+}
+
+func TestReplaceItemPkg(t *testing.T) {
+	dir := createSyncModule(t, "example.com/pkg")
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte(`package main
+
+import "golang.org/x/sync/errgroup"
+
+func main() {
+	errgroup.AdditionalFuncByUwagaki()
+}
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(dir)
+
+	env, err := uwagaki.NewEnvironment([]string{"."}, []uwagaki.ReplaceItem{
+		{
+			Pkg:  "golang.org/x/sync/errgroup",
+			Path: "additional_file_by_uwagaki.go",
+			Content: []byte(`package errgroup
+
+import "fmt"
+
+func AdditionalFuncByUwagaki() {
+	fmt.Println("Hello, Uwagaki (errgroup)!")
+}
+`),
+		},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer env.Close()
+
+	cmd := exec.Command("go", "run")
+	cmd.Args = append(cmd.Args, env.Paths()...)
+	cmd.Dir = env.Dir()
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	if got, want := strings.TrimSpace(string(out)), "Hello, Uwagaki (errgroup)!"; got != want {
+		t.Errorf("output: got: %q, want: %q", got, want)
+	}
+
+	for _, item := range []uwagaki.ReplaceItem{
+		{Mod: "golang.org/x/sync", Pkg: "golang.org/x/sync/errgroup", Path: "foo.go"},
+		{Pkg: "golang.org/x/sync/errgroup", Path: "foo/foo.go"},
+	} {
+		if err := env.Update([]uwagaki.ReplaceItem{item}); err == nil {
+			t.Errorf("Update with %v must fail", item)
+		}
+	}
+}
+
+func TestReplaceItemValidate(t *testing.T) {
+	testCases := []struct {
+		Item  uwagaki.ReplaceItem
+		Valid bool
+	}{
+		{Item: uwagaki.ReplaceItem{Mod: "golang.org/x/sync", Path: "errgroup/errgroup.go"}, Valid: true},
+		{Item: uwagaki.ReplaceItem{Mod: "golang.org/x/sync", Path: ".github/foo.yml"}, Valid: true},
+		{Item: uwagaki.ReplaceItem{Pkg: "golang.org/x/sync/errgroup", Path: "foo.go"}, Valid: true},
+		{Item: uwagaki.ReplaceItem{Mod: "golang.org/x/sync", Path: ""}},
+		{Item: uwagaki.ReplaceItem{Mod: "golang.org/x/sync", Path: "."}},
+		{Item: uwagaki.ReplaceItem{Mod: "golang.org/x/sync", Path: "../../../../etc/x"}},
+		{Item: uwagaki.ReplaceItem{Mod: "golang.org/x/sync", Path: "foo/../../x"}},
+		{Item: uwagaki.ReplaceItem{Mod: "golang.org/x/sync", Path: "/etc/x"}},
+		{Item: uwagaki.ReplaceItem{Mod: "golang.org/x/sync", Path: "C:/x"}},
+		{Item: uwagaki.ReplaceItem{Mod: "golang.org/x/sync", Path: `foo\bar.go`}},
+		{Item: uwagaki.ReplaceItem{Mod: "golang.org/x/sync", Path: "./foo.go"}},
+		{Item: uwagaki.ReplaceItem{Mod: "golang.org/x/sync", Path: "foo//bar.go"}},
+		{Item: uwagaki.ReplaceItem{Mod: "golang.org/x/sync", Path: ".git/config"}},
+		{Item: uwagaki.ReplaceItem{Mod: "golang.org/x/sync", Path: "foo/.GIT/config"}},
+		{Item: uwagaki.ReplaceItem{Mod: "../golang.org/x/sync", Path: "foo.go"}},
+		{Item: uwagaki.ReplaceItem{Mod: "sync", Path: "foo.go"}},
+		{Item: uwagaki.ReplaceItem{Path: "foo.go"}},
+		{Item: uwagaki.ReplaceItem{Mod: "golang.org/x/sync", Pkg: "golang.org/x/sync/errgroup", Path: "foo.go"}},
+		{Item: uwagaki.ReplaceItem{Pkg: "golang.org/x/sync/errgroup", Path: "foo/foo.go"}},
+		{Item: uwagaki.ReplaceItem{Mod: "golang.org/x/sync", Pattern: "**/*_test.go", Delete: true}, Valid: true},
+		{Item: uwagaki.ReplaceItem{Pkg: "golang.org/x/sync/errgroup", Pattern: "*_test.go", Delete: true}, Valid: true},
+		{Item: uwagaki.ReplaceItem{Mod: "golang.org/x/sync", Path: "foo.go", Delete: true}, Valid: true},
+		{Item: uwagaki.ReplaceItem{Mod: "golang.org/x/sync", Pattern: "*.go", Content: []byte("package foo\n")}},
+		{Item: uwagaki.ReplaceItem{Mod: "golang.org/x/sync", Pattern: "*.go", Path: "foo.go", Delete: true}},
+		{Item: uwagaki.ReplaceItem{Mod: "golang.org/x/sync", Pattern: "../*.go", Delete: true}},
+		{Item: uwagaki.ReplaceItem{Mod: "golang.org/x/sync", Pattern: "[.go", Delete: true}},
+		{Item: uwagaki.ReplaceItem{Mod: "golang.org/x/sync", Pattern: ".git/*", Delete: true}},
+		{Item: uwagaki.ReplaceItem{Pkg: "golang.org/x/sync/errgroup", Pattern: "**/*.go", Delete: true}},
+		{Item: uwagaki.ReplaceItem{Mod: "golang.org/x/sync", Path: "foo.go", Content: []byte("package foo\n"), Delete: true}},
+		{Item: uwagaki.ReplaceItem{Mod: "golang.org/x/sync", Path: "foo.go", Version: ">= v0.22.0, < v0.25.0"}, Valid: true},
+		{Item: uwagaki.ReplaceItem{Mod: "golang.org/x/sync", Path: "foo.go", Version: "v0.22.0"}, Valid: true},
+		{Item: uwagaki.ReplaceItem{Mod: "golang.org/x/sync", Path: "foo.go", Version: "!=v0.22.0,<=v1"}, Valid: true},
+		{Item: uwagaki.ReplaceItem{Mod: "golang.org/x/sync", Path: "foo.go", Version: ">= 0.22.0"}},
+		{Item: uwagaki.ReplaceItem{Mod: "golang.org/x/sync", Path: "foo.go", Version: ">= v0.22.0,"}},
+		{Item: uwagaki.ReplaceItem{Mod: "golang.org/x/sync", Path: "foo.go", Version: "~> v0.22.0"}},
+		{Item: uwagaki.ReplaceItem{Mod: "golang.org/x/sync", Path: "foo.go", BaseHash: uwagaki.Hash(nil)}, Valid: true},
+		{Item: uwagaki.ReplaceItem{Mod: "golang.org/x/sync", Path: "foo.go", BaseHash: "sha256:1234"}},
+		{Item: uwagaki.ReplaceItem{Mod: "golang.org/x/sync", Path: "foo.go", BaseHash: strings.ToUpper(uwagaki.Hash(nil))}},
+		{Item: uwagaki.ReplaceItem{Mod: "golang.org/x/sync", Pattern: "*.go", Delete: true, BaseHash: uwagaki.Hash(nil)}},
+	}
+	for _, tc := range testCases {
+		err := tc.Item.Validate()
+		if tc.Valid {
+			if err != nil {
+				t.Errorf("Validate(%q, %q): got: %v, want: nil", tc.Item.Mod, tc.Item.Path, err)
+			}
+			continue
+		}
+		var invalidErr *uwagaki.InvalidReplaceItemError
+		if !errors.As(err, &invalidErr) {
+			t.Errorf("Validate(%q, %q): got: %v, want: *InvalidReplaceItemError", tc.Item.Mod, tc.Item.Path, err)
+		}
+	}
+}
+
+func TestInvalidReplaceItem(t *testing.T) {
+	dir := t.TempDir()
+	outside := filepath.Join(dir, "outside.go")
+	modDir := filepath.Join(dir, "foo")
+	writeFiles(t, modDir, map[string]string{
+		"go.mod":  "module foo\n\ngo 1.24\n",
+		"main.go": "package main\n\nfunc main() {}\n",
+	})
+	t.Chdir(modDir)
+
+	// Creating an environment must fail before writing anything.
+	if _, err := uwagaki.NewEnvironment([]string{"."}, []uwagaki.ReplaceItem{
+		{Mod: "foo", Path: "../outside.go", Content: []byte("package main\n")},
+	}, nil); err == nil {
+		t.Errorf("NewEnvironment must fail")
+	}
+
+	// -mod=mod is not available in workspace mode.
+	t.Setenv("GOFLAGS", "")
+	env, err := uwagaki.NewEnvironment([]string{"."}, nil, &uwagaki.Options{
+		Layout: uwagaki.LayoutWorkspace,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer env.Close()
+
+	// A main module path doesn't have to be a valid module path for publishing.
+	if err := env.Update([]uwagaki.ReplaceItem{
+		{Mod: "foo", Path: "foo.go", Content: []byte("package main\n")},
+	}); err != nil {
+		t.Error(err)
+	}
+
+	var invalidErr *uwagaki.InvalidReplaceItemError
+	if err := env.Update([]uwagaki.ReplaceItem{
+		{Mod: "foo", Path: "../../outside.go", Content: []byte("package main\n")},
+	}); !errors.As(err, &invalidErr) {
+		t.Errorf("Update: got: %v, want: *InvalidReplaceItemError", err)
+	}
+	if _, err := os.Stat(outside); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("os.Stat(%q): got: %v, want: %v", outside, err, fs.ErrNotExist)
+	}
+}
+
+func TestReplaceItemPattern(t *testing.T) {
+	dir := createSyncModule(t, "example.com/pattern")
+	patch := mustReadFile("./testdata/sync/additional_file_by_uwagaki.go")
+	t.Chdir(dir)
+
+	const header = "// Patched by uwagaki.\n\n"
+	var transformed []string
+	items := []uwagaki.ReplaceItem{
+		{
+			Mod:     "golang.org/x/sync",
+			Path:    "additional_file_by_uwagaki.go",
+			Content: patch,
+		},
+		{
+			Mod:     "golang.org/x/sync",
+			Path:    "errgroup/foo.go",
+			Content: []byte("package errgroup\n"),
+		},
+		{
+			Mod:     "golang.org/x/sync",
+			Pattern: "**/*_test.go",
+			Delete:  true,
+		},
+		{
+			Pkg:     "golang.org/x/sync/errgroup",
+			Pattern: "*.go",
+			Transform: func(path string, content []byte) ([]byte, error) {
+				transformed = append(transformed, path)
+				return append([]byte(header), content...), nil
+			},
+		},
+	}
+	env, err := uwagaki.NewEnvironment([]string{"."}, items, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer env.Close()
+
+	// The transform is applied to the files after the previous items are applied.
+	if got, want := transformed, []string{
+		"errgroup/errgroup.go",
+		"errgroup/foo.go",
+		"errgroup/go120.go",
+		"errgroup/pre_go120.go",
+	}; !slices.Equal(got, want) {
+		t.Errorf("transformed: got: %v, want: %v", got, want)
+	}
+
+	modDir := filepath.Join(env.Dir(), "mod", "golang.org", "x", "sync+v0.11.0")
+	for _, name := range []string{"errgroup/errgroup_test.go", "errgroup/go120_test.go", "semaphore/semaphore_test.go"} {
+		if _, err := os.Stat(filepath.Join(modDir, filepath.FromSlash(name))); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("os.Stat(%q): got: %v, want: %v", name, err, fs.ErrNotExist)
+		}
+	}
+	for _, name := range []string{"errgroup/errgroup.go", "errgroup/foo.go"} {
+		content, err := os.ReadFile(filepath.Join(modDir, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(string(content), header) {
+			t.Errorf("%s must start with the header", name)
+		}
+	}
+
+	cmd := exec.Command("go", "run")
+	cmd.Args = append(cmd.Args, env.Paths()...)
+	cmd.Dir = env.Dir()
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	if got, want := strings.TrimSpace(string(out)), "Hello, Uwagaki (sync)!"; got != want {
+		t.Errorf("output: got: %q, want: %q", got, want)
+	}
+
+	// A pattern matching no files is an error.
+	if err := env.Update([]uwagaki.ReplaceItem{
+		{Mod: "golang.org/x/sync", Pattern: "**/*_windows.go", Delete: true},
+	}); err == nil {
+		t.Errorf("Update with a pattern matching no files must fail")
+	}
+
+	// The removed files are restored.
+	if err := env.Update(nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(modDir, "errgroup", "errgroup_test.go")); err != nil {
+		t.Errorf("the removed file must be restored: %v", err)
+	}
+	content, err := os.ReadFile(filepath.Join(modDir, "errgroup", "errgroup.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.HasPrefix(string(content), header) {
+		t.Errorf("the transformed file must be restored")
+	}
+}
+
+func TestReplaceItemVersion(t *testing.T) {
+	dir := createSyncModule(t, "example.com/version")
+	patch := string(mustReadFile("./testdata/sync/additional_file_by_uwagaki.go"))
+	t.Chdir(dir)
+
+	item := func(version, greeting string) uwagaki.ReplaceItem {
+		return uwagaki.ReplaceItem{
+			Mod:     "golang.org/x/sync",
+			Path:    "additional_file_by_uwagaki.go",
+			Content: []byte(strings.Replace(patch, "Hello", greeting, 1)),
+			Version: version,
+		}
+	}
+
+	env, err := uwagaki.NewEnvironment([]string{"."}, []uwagaki.ReplaceItem{
+		item("< v0.11.0", "Hello (old)"),
+		item(">= v0.11.0, < v0.12.0", "Hello (v0.11)"),
+		item(">= v0.12.0", "Hello (new)"),
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer env.Close()
+
+	cmd := exec.Command("go", "run")
+	cmd.Args = append(cmd.Args, env.Paths()...)
+	cmd.Dir = env.Dir()
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	if got, want := strings.TrimSpace(string(out)), "Hello (v0.11), Uwagaki (sync)!"; got != want {
+		t.Errorf("output: got: %q, want: %q", got, want)
+	}
+
+	// Overlapping constraints are conflicts.
+	var conflictErr *uwagaki.ConflictError
+	if err := env.Update([]uwagaki.ReplaceItem{
+		item(">= v0.10.0", "Hello (1)"),
+		item("v0.11.0", "Hello (2)"),
+	}); !errors.As(err, &conflictErr) {
+		t.Errorf("Update: got: %v, want: *ConflictError", err)
+	}
+
+	// No items matching the module version is an error.
+	if err := env.Update([]uwagaki.ReplaceItem{
+		item("< v0.11.0", "Hello (old)"),
+		item("> v0.11.0", "Hello (new)"),
+	}); err == nil || !strings.Contains(err.Error(), "v0.11.0") {
+		t.Errorf("Update: got: %v, want: an error with the module version", err)
+	}
+
+	// A module without matched items is not copied to the environment.
+	env2, err := uwagaki.NewEnvironment([]string{"."}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer env2.Close()
+	if err := env2.Update([]uwagaki.ReplaceItem{
+		item("< v0.11.0", "Hello (old)"),
+	}); err == nil {
+		t.Errorf("Update must fail")
+	}
+	if _, err := os.Stat(filepath.Join(env2.Dir(), "mod")); !os.IsNotExist(err) {
+		t.Errorf("the module must not be copied: %v", err)
+	}
+	if content := mustReadFile(filepath.Join(env2.Dir(), "go.mod")); bytes.Contains(content, []byte("golang.org/x/sync =>")) {
+		t.Errorf("go.mod must not have a replace directive for the module:\n%s", content)
+	}
+}
+
+func TestReplaceItemBaseHash(t *testing.T) {
+	dir := createSyncModule(t, "example.com/basehash")
+	t.Chdir(dir)
+
+	out, err := exec.Command("go", "list", "-m", "-f", "{{.Dir}}", "golang.org/x/sync").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig, err := os.ReadFile(filepath.Join(strings.TrimSpace(string(out)), "errgroup", "errgroup.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	origHash := uwagaki.Hash(orig)
+	staleHash := uwagaki.Hash([]byte("package errgroup\n"))
+
+	item := func(path, baseHash string) uwagaki.ReplaceItem {
+		return uwagaki.ReplaceItem{
+			Mod:      "golang.org/x/sync",
+			Path:     path,
+			Content:  []byte("package errgroup\n"),
+			BaseHash: baseHash,
+		}
+	}
+
+	env, err := uwagaki.NewEnvironment([]string{"."}, []uwagaki.ReplaceItem{item("errgroup/errgroup.go", origHash)}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer env.Close()
+
+	var driftErr *uwagaki.DriftError
+	if err := env.Update([]uwagaki.ReplaceItem{item("errgroup/errgroup.go", staleHash)}); !errors.As(err, &driftErr) {
+		t.Fatalf("Update: got: %v, want: *DriftError", err)
+	}
+	if driftErr.Version != "v0.11.0" {
+		t.Errorf("Version: got: %s, want: %s", driftErr.Version, "v0.11.0")
+	}
+	if driftErr.Want != staleHash {
+		t.Errorf("Want: got: %s, want: %s", driftErr.Want, staleHash)
+	}
+	if driftErr.Got != origHash {
+		t.Errorf("Got: got: %s, want: %s", driftErr.Got, origHash)
+	}
+
+	// A new file doesn't match any hash.
+	if err := env.Update([]uwagaki.ReplaceItem{item("errgroup/foo.go", staleHash)}); !errors.As(err, &driftErr) {
+		t.Fatalf("Update: got: %v, want: *DriftError", err)
+	}
+	if driftErr.Got != "" {
+		t.Errorf("Got: got: %s, want: empty", driftErr.Got)
+	}
+
+	// OnDrift can ignore drifts.
+	var drifts []*uwagaki.DriftError
+	env2, err := uwagaki.NewEnvironment([]string{"."}, []uwagaki.ReplaceItem{item("errgroup/errgroup.go", staleHash)}, &uwagaki.Options{
+		OnDrift: func(err *uwagaki.DriftError) error {
+			drifts = append(drifts, err)
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer env2.Close()
+	if got, want := len(drifts), 1; got != want {
+		t.Errorf("len(drifts): got: %d, want: %d", got, want)
+	}
+}
+
+func TestEnvironmentCommand(t *testing.T) {
+	content := mustReadFile("./testdata/sync/additional_file_by_uwagaki.go")
+	dir := createSyncModule(t, "example.com/command")
+	t.Chdir(dir)
+
+	for _, layout := range []uwagaki.Layout{uwagaki.LayoutModule, uwagaki.LayoutModFile} {
+		env, err := uwagaki.NewEnvironment([]string{"."}, []uwagaki.ReplaceItem{
+			{
+				Mod:     "golang.org/x/sync",
+				Path:    "additional_file_by_uwagaki.go",
+				Content: content,
+			},
+		}, &uwagaki.Options{
+			Layout: layout,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer env.Close()
+
+		args := env.TranslateArgs([]string{"run", "."})
+		if got, want := args, []string{"run", env.Paths()[0]}; !slices.Equal(got, want) {
+			t.Errorf("TranslateArgs (layout: %d): got: %q, want: %q", layout, got, want)
+		}
+		out, err := env.Command("go", args...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("layout: %d: %v\n%s", layout, err, out)
+		}
+		if got, want := strings.TrimSpace(string(out)), "Hello, Uwagaki (sync)!"; got != want {
+			t.Errorf("output (layout: %d): got: %q, want: %q", layout, got, want)
+		}
+	}
+}
+
+func TestEnvironmentToolCommand(t *testing.T) {
+	// GOFLAGS=-mod=mod doesn't work with workspaces.
+	t.Setenv("GOFLAGS", "")
+
+	content := mustReadFile("./testdata/sync/additional_file_by_uwagaki.go")
+	tool := mustReadFile("./testdata/stringer/main.go")
+
+	for _, byDirective := range []bool{true, false} {
+		for _, layout := range []uwagaki.Layout{uwagaki.LayoutModule, uwagaki.LayoutWorkspace, uwagaki.LayoutModFile} {
+			t.Run(fmt.Sprintf("layout=%d,directive=%t", layout, byDirective), func(t *testing.T) {
+				dir := createSyncModule(t, "example.com/tool")
+				writeFiles(t, dir, map[string]string{
+					"cmd/hello/main.go": string(tool),
+				})
+				var tools []string
+				if byDirective {
+					cmd := exec.Command("go", "mod", "edit", "-tool=example.com/tool/cmd/hello")
+					cmd.Dir = dir
+					if out, err := cmd.CombinedOutput(); err != nil {
+						t.Fatalf("%v\n%s", err, out)
+					}
+				} else {
+					tools = []string{"example.com/tool/cmd/hello"}
+				}
+				goMod := mustReadFile(filepath.Join(dir, "go.mod"))
+				t.Chdir(dir)
+
+				env, err := uwagaki.NewEnvironment(nil, []uwagaki.ReplaceItem{
+					{
+						Mod:     "golang.org/x/sync",
+						Path:    "additional_file_by_uwagaki.go",
+						Content: content,
+					},
+				}, &uwagaki.Options{
+					Layout: layout,
+					Tools:  tools,
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer env.Close()
+
+				cmd, err := env.ToolCommand("hello")
+				if err != nil {
+					t.Fatal(err)
+				}
+				out, err := cmd.CombinedOutput()
+				if err != nil {
+					t.Fatalf("%v\n%s", err, out)
+				}
+				if got, want := strings.TrimSpace(string(out)), "Hello, Uwagaki (sync)!"; got != want {
+					t.Errorf("output: got: %q, want: %q", got, want)
+				}
+
+				// The current go.mod is not changed.
+				if got, want := string(mustReadFile("go.mod")), string(goMod); got != want {
+					t.Errorf("go.mod: got: %q, want: %q", got, want)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,182 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2025 Hajime Hoshi
+
+package uwagaki
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/mod/module"
+)
+
+// InvalidReplaceItemError is an error for an invalid ReplaceItem.
+type InvalidReplaceItemError struct {
+	// Mod is ReplaceItem.Mod.
+	Mod string
+
+	// Pkg is ReplaceItem.Pkg.
+	Pkg string
+
+	// Path is ReplaceItem.Path.
+	Path string
+
+	// Reason describes why the item is invalid.
+	Reason string
+
+	// Err is the underlying error if exists.
+	Err error
+}
+
+// Error implements error.
+func (e *InvalidReplaceItemError) Error() string {
+	target := e.Mod
+	if e.Pkg != "" {
+		target = e.Pkg
+	}
+	msg := fmt.Sprintf("uwagaki: invalid ReplaceItem (%q, %q): %s", target, e.Path, e.Reason)
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// Unwrap returns the underlying error.
+func (e *InvalidReplaceItemError) Unwrap() error {
+	return e.Err
+}
+
+// Validate reports an error if the item is invalid.
+// The returned error is an *InvalidReplaceItemError.
+//
+// Mod must be a valid module path (see module.CheckPath), and Pkg must be a valid import path.
+// Path must be a clean and portable slash-separated relative path without '..' or '.git' elements (see module.CheckFilePath).
+// Pattern must be a valid pattern following the same rules except for portability.
+// Path and Pattern are exclusive, and Content, Delete, and Transform are exclusive.
+// Version must be a valid version constraint, and BaseHash must be in the format of Hash.
+//
+// Validate doesn't access the file system or the network,
+// so it can be used to validate items built from untrusted data before creating an environment.
+func (r *ReplaceItem) Validate() error {
+	return validateReplaceItem(r, nil)
+}
+
+// validateReplaceItem validates the item.
+// If isMainModule reports true for Mod, Mod is validated as an import path, as a main module path doesn't have to be a valid module path for publishing.
+func validateReplaceItem(r *ReplaceItem, isMainModule func(modulePath string) bool) error {
+	newErr := func(reason string, err error) error {
+		return &InvalidReplaceItemError{
+			Mod:    r.Mod,
+			Pkg:    r.Pkg,
+			Path:   r.Path,
+			Reason: reason,
+			Err:    err,
+		}
+	}
+
+	switch {
+	case r.Mod != "" && r.Pkg != "":
+		return newErr("Mod and Pkg cannot be specified at the same time", nil)
+	case r.Mod == "" && r.Pkg == "":
+		return newErr("either Mod or Pkg must be specified", nil)
+	case r.Mod != "":
+		if isMainModule != nil && isMainModule(r.Mod) {
+			if err := module.CheckImportPath(r.Mod); err != nil {
+				return newErr("invalid module path", err)
+			}
+			break
+		}
+		if err := module.CheckPath(r.Mod); err != nil {
+			return newErr("invalid module path", err)
+		}
+	case r.Pkg != "":
+		if err := module.CheckImportPath(r.Pkg); err != nil {
+			return newErr("invalid package path", err)
+		}
+		if strings.Contains(r.Path, "/") {
+			return newErr("path must be a file name when Pkg is specified", nil)
+		}
+		if strings.Contains(r.Pattern, "/") {
+			return newErr("pattern must be a pattern of file names when Pkg is specified", nil)
+		}
+	}
+
+	if r.Version != "" {
+		if _, err := parseVersionConstraint(r.Version); err != nil {
+			return newErr("invalid version constraint", err)
+		}
+	}
+
+	if r.BaseHash != "" && !isValidHash(r.BaseHash) {
+		return newErr("invalid base hash", nil)
+	}
+
+	actions := 0
+	if r.Content != nil {
+		actions++
+	}
+	if r.Delete {
+		actions++
+	}
+	if r.Transform != nil {
+		actions++
+	}
+	if actions > 1 {
+		return newErr("Content, Delete, and Transform are exclusive", nil)
+	}
+
+	switch {
+	case r.Path != "" && r.Pattern != "":
+		return newErr("Path and Pattern cannot be specified at the same time", nil)
+	case r.Pattern != "":
+		if !r.Delete && r.Transform == nil {
+			return newErr("Pattern requires Delete or Transform", nil)
+		}
+		if r.BaseHash != "" {
+			return newErr("BaseHash cannot be used with Pattern", nil)
+		}
+		if reason := checkItemPath(r.Pattern); reason != "" {
+			return newErr("pattern "+reason, nil)
+		}
+		for _, elem := range strings.Split(r.Pattern, "/") {
+			if _, err := path.Match(elem, ""); err != nil {
+				return newErr("invalid pattern", err)
+			}
+		}
+		return nil
+	}
+
+	if reason := checkItemPath(r.Path); reason != "" {
+		return newErr("path "+reason, nil)
+	}
+	// Reject non-portable paths like "C:/foo" or "aux.go" even on platforms where they are valid.
+	if err := module.CheckFilePath(r.Path); err != nil {
+		return newErr("invalid file path", err)
+	}
+	return nil
+}
+
+// checkItemPath checks the path or the pattern of an item, and returns the reason if the path is invalid.
+func checkItemPath(p string) string {
+	switch {
+	case p == "":
+		return "must not be empty"
+	case strings.Contains(p, `\`):
+		return "must be slash-separated"
+	case path.IsAbs(p) || filepath.IsAbs(p) || filepath.VolumeName(filepath.FromSlash(p)) != "":
+		return "must be relative"
+	case path.Clean(p) != p || p == ".":
+		return "must be clean"
+	}
+	for _, elem := range strings.Split(p, "/") {
+		if elem == ".." {
+			return "must not include '..'"
+		}
+		if strings.EqualFold(elem, ".git") {
+			return "must not include '.git'"
+		}
+	}
+	return ""
+}
@@ -0,0 +1,79 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2025 Hajime Hoshi
+
+package uwagaki
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/mod/semver"
+)
+
+// versionClause represents a comparison with a version like '>= v0.22.0'.
+type versionClause struct {
+	op      string
+	version string
+}
+
+// versionConstraint represents a list of clauses that must be all satisfied.
+type versionConstraint []versionClause
+
+// versionOps is a list of the operators.
+// A longer operator must come before its prefix.
+var versionOps = []string{">=", "<=", "!=", ">", "<", "="}
+
+// parseVersionConstraint parses a comma-separated list of clauses like '>= v0.22.0, < v0.25.0'.
+// A clause without an operator means '='.
+func parseVersionConstraint(str string) (versionConstraint, error) {
+	var c versionConstraint
+	for _, clause := range strings.Split(str, ",") {
+		clause = strings.TrimSpace(clause)
+		op := "="
+		for _, o := range versionOps {
+			if strings.HasPrefix(clause, o) {
+				op = o
+				clause = strings.TrimSpace(clause[len(o):])
+				break
+			}
+		}
+		if !semver.IsValid(clause) {
+			return nil, fmt.Errorf("uwagaki: invalid version in constraint %q: %q", str, clause)
+		}
+		c = append(c, versionClause{
+			op:      op,
+			version: clause,
+		})
+	}
+	return c, nil
+}
+
+// match reports whether the version satisfies all the clauses.
+// match returns false for an invalid version.
+func (c versionConstraint) match(version string) bool {
+	if !semver.IsValid(version) {
+		return false
+	}
+	for _, clause := range c {
+		r := semver.Compare(version, clause.version)
+		var ok bool
+		switch clause.op {
+		case "=":
+			ok = r == 0
+		case "!=":
+			ok = r != 0
+		case "<":
+			ok = r < 0
+		case "<=":
+			ok = r <= 0
+		case ">":
+			ok = r > 0
+		case ">=":
+			ok = r >= 0
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,258 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2025 Hajime Hoshi
+
+package uwagaki
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// WatchOptions represents options for Environment.Watch.
+type WatchOptions struct {
+	// Interval is the polling interval.
+	// If Interval is 0, 500 milliseconds is used.
+	Interval time.Duration
+
+	// GoArgs is a go subcommand and its flags that are run after every update, like []string{"test", "-v"}.
+	// The environment's paths are appended to GoArgs.
+	// If GoArgs is empty, no command is run.
+	//
+	// If the files change while the command is running, e.g. a server by 'go run', the command is killed and run again after the update.
+	// The command is also killed when the context of Watch is done.
+	GoArgs []string
+
+	// Args is a list of arguments appended after the environment's paths, like program arguments for 'go run'.
+	Args []string
+
+	// Output is a writer to which the output of the command is also written while the command runs.
+	// This is useful to see the output of a long-running command.
+	// If Output is nil, the output is only reported by WatchResult.Output.
+	Output io.Writer
+
+	// OnUpdate is called after every update.
+	// If a command is run, OnUpdate is called after the command finishes.
+	// OnUpdate is not called for a command killed by a next update or the context.
+	OnUpdate func(result *WatchResult)
+}
+
+// WatchResult represents a result of an update by Environment.Watch.
+type WatchResult struct {
+	// Items is a list of ReplaceItem loaded from the patch directories.
+	Items []ReplaceItem
+
+	// Output is the combined output of the go command.
+	// Output is nil if no command is run.
+	Output []byte
+
+	// Err is an error at updating the environment or running the go command.
+	Err error
+}
+
+// Watch watches the patch directories, and updates the environment whenever files in them change.
+//
+// The files in the patch directories are added to the ReplaceItems that the environment is created or updated with.
+// Each patch directory is a layer of MergeLayers, so a file in a later directory overrides the same file in an earlier directory or in the ReplaceItems.
+// The directories are polled, so Watch doesn't depend on file system notifications.
+//
+// Watch updates the environment once at the beginning, and then blocks until ctx is done.
+// Errors at updates are reported to WatchOptions.OnUpdate and don't stop watching.
+// Watch returns ctx's error.
+func (e *Environment) Watch(ctx context.Context, dirs []PatchDir, options *WatchOptions) error {
+	if options == nil {
+		options = &WatchOptions{}
+	}
+	interval := options.Interval
+	if interval == 0 {
+		interval = 500 * time.Millisecond
+	}
+
+	base := slices.Clone(e.replaces)
+
+	var lastStamps map[string]fileStamp
+	var lastErr error
+	first := true
+	var running *watchRun
+	defer func() {
+		if running != nil {
+			running.stop()
+		}
+	}()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		stamps, err := stampPatchDirs(dirs)
+		// Report an error only when the error state changes, not to report the same error repeatedly.
+		if first || !maps.Equal(stamps, lastStamps) || (err == nil) != (lastErr == nil) {
+			first = false
+			lastStamps = stamps
+			lastErr = err
+			if running != nil {
+				running.stop()
+				running = nil
+			}
+			r := &WatchResult{
+				Err: err,
+			}
+			if err == nil {
+				running = e.watchUpdate(ctx, r, base, dirs, options)
+			}
+			if running == nil && options.OnUpdate != nil {
+				options.OnUpdate(r)
+			}
+		}
+
+		var done <-chan struct{}
+		if running != nil {
+			done = running.done
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		case <-done:
+			r := running.result
+			running = nil
+			if options.OnUpdate != nil {
+				options.OnUpdate(r)
+			}
+		}
+	}
+}
+
+// watchRun represents a go command running in Watch.
+type watchRun struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	// result is available after done is closed.
+	result *WatchResult
+}
+
+// stop kills the command and waits for it.
+func (w *watchRun) stop() {
+	w.cancel()
+	<-w.done
+}
+
+// watchUpdate updates the environment and starts the go command.
+// watchUpdate returns nil if no command is started.
+func (e *Environment) watchUpdate(ctx context.Context, result *WatchResult, base []ReplaceItem, dirs []PatchDir, options *WatchOptions) *watchRun {
+	layers := [][]ReplaceItem{base}
+	for _, d := range dirs {
+		is, err := d.ReplaceItems()
+		if err != nil {
+			result.Err = err
+			return nil
+		}
+		result.Items = append(result.Items, is...)
+		layers = append(layers, is)
+	}
+
+	items, err := MergeLayers(layers...)
+	if err != nil {
+		result.Err = err
+		return nil
+	}
+
+	if err := e.Update(items); err != nil {
+		result.Err = err
+		return nil
+	}
+
+	if len(options.GoArgs) == 0 {
+		return nil
+	}
+	args := slices.Concat(options.GoArgs, e.Paths(), options.Args)
+	ctx, cancel := context.WithCancel(ctx)
+	cmd := e.commandContext(ctx, "go", args...)
+	killProcessGroupOnCancel(cmd)
+	var buf bytes.Buffer
+	var out io.Writer = &buf
+	if options.Output != nil {
+		out = io.MultiWriter(&buf, options.Output)
+	}
+	cmd.Stdout = out
+	cmd.Stderr = out
+	if err := cmd.Start(); err != nil {
+		cancel()
+		result.Err = err
+		return nil
+	}
+
+	w := &watchRun{
+		cancel: cancel,
+		done:   make(chan struct{}),
+		result: result,
+	}
+	go func() {
+		defer close(w.done)
+		err := cmd.Wait()
+		cancel()
+		result.Output = buf.Bytes()
+		result.Err = err
+	}()
+	return w
+}
+
+// maxHashedFileSize is the maximum size of a file whose content hash is used to detect changes.
+const maxHashedFileSize = 1 << 20
+
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+
+	// hash is the hash of the content, as the modification time might not change by a quick edit.
+	// hash is empty for a large file.
+	hash string
+}
+
+// stampPatchDirs returns a map from file paths to their stamps to detect changes.
+func stampPatchDirs(dirs []PatchDir) (map[string]fileStamp, error) {
+	stamps := map[string]fileStamp{}
+	for _, d := range dirs {
+		if err := filepath.WalkDir(d.Dir, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if entry.IsDir() {
+				return nil
+			}
+			info, err := entry.Info()
+			if err != nil {
+				// The file might be removed after walking the directory.
+				if errors.Is(err, fs.ErrNotExist) {
+					return nil
+				}
+				return err
+			}
+			stamp := fileStamp{
+				size:    info.Size(),
+				modTime: info.ModTime(),
+			}
+			if info.Mode().IsRegular() && info.Size() <= maxHashedFileSize {
+				content, err := os.ReadFile(path)
+				if err != nil {
+					if errors.Is(err, fs.ErrNotExist) {
+						return nil
+					}
+					return err
+				}
+				stamp.hash = Hash(content)
+			}
+			stamps[path] = stamp
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return stamps, nil
+}
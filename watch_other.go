@@ -0,0 +1,18 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2025 Hajime Hoshi
+
+//go:build !unix
+
+package uwagaki
+
+import (
+	"os/exec"
+	"time"
+)
+
+// killProcessGroupOnCancel makes cmd killed when its context is done.
+// Only the go command is killed, as there is no portable way to kill its child processes.
+func killProcessGroupOnCancel(cmd *exec.Cmd) {
+	// A child process might keep the output pipe open.
+	cmd.WaitDelay = time.Second
+}
@@ -0,0 +1,171 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2025 Hajime Hoshi
+
+package uwagaki_test
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hajimehoshi/uwagaki"
+)
+
+// createSyncModule creates a temporary module that requires golang.org/x/sync v0.11.0 and calls a function added by uwagaki.
+func createSyncModule(t *testing.T, mod string) string {
+	dir := t.TempDir()
+	{
+		cmd := exec.Command("go", "mod", "init", mod)
+		cmd.Stderr = os.Stderr
+		cmd.Dir = dir
+		if err := cmd.Run(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	{
+		cmd := exec.Command("go", "get", "golang.org/x/sync@v0.11.0")
+		cmd.Stderr = os.Stderr
+		cmd.Dir = dir
+		if err := cmd.Run(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), mustReadFile("./testdata/stringer/main.go"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestWatch(t *testing.T) {
+	dir := createSyncModule(t, "example.com/watch")
+	patch := mustReadFile("./testdata/sync/additional_file_by_uwagaki.go")
+	patchDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(patchDir, "additional_file_by_uwagaki.go"), patch, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Chdir(dir)
+	env, err := uwagaki.NewEnvironment([]string{"."}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer env.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var outputs []string
+	err = env.Watch(ctx, []uwagaki.PatchDir{{Mod: "golang.org/x/sync", Dir: patchDir}}, &uwagaki.WatchOptions{
+		GoArgs: []string{"run"},
+		OnUpdate: func(result *uwagaki.WatchResult) {
+			if result.Err != nil {
+				t.Errorf("%v\n%s", result.Err, result.Output)
+				cancel()
+				return
+			}
+			outputs = append(outputs, strings.TrimSpace(string(result.Output)))
+			if len(outputs) == 2 {
+				cancel()
+				return
+			}
+			content := strings.Replace(string(patch), "Hello", "Hello again", 1)
+			if err := os.WriteFile(filepath.Join(patchDir, "additional_file_by_uwagaki.go"), []byte(content), 0644); err != nil {
+				t.Error(err)
+				cancel()
+			}
+		},
+	})
+	if err != context.Canceled {
+		t.Fatal(err)
+	}
+
+	if got, want := strings.Join(outputs, "\n"), "Hello, Uwagaki (sync)!\nHello again, Uwagaki (sync)!"; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+}
+
+func TestWatchLongRunning(t *testing.T) {
+	dir := createSyncModule(t, "example.com/watch")
+	patch := mustReadFile("./testdata/sync/additional_file_by_uwagaki.go")
+	patchDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(patchDir, "additional_file_by_uwagaki.go"), patch, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The program keeps running like a server.
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte(`package main
+
+import (
+	"time"
+
+	"golang.org/x/sync"
+)
+
+func main() {
+	sync.AdditionalFuncByUwagaki()
+	time.Sleep(time.Hour)
+}
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Chdir(dir)
+	env, err := uwagaki.NewEnvironment([]string{"."}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer env.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pr, pw := io.Pipe()
+	lines := make(chan string)
+	go func() {
+		s := bufio.NewScanner(pr)
+		for s.Scan() {
+			lines <- s.Text()
+		}
+	}()
+
+	watchErr := make(chan error)
+	go func() {
+		watchErr <- env.Watch(ctx, []uwagaki.PatchDir{{Mod: "golang.org/x/sync", Dir: patchDir}}, &uwagaki.WatchOptions{
+			Interval: 10 * time.Millisecond,
+			GoArgs:   []string{"run"},
+			Output:   pw,
+			OnUpdate: func(result *uwagaki.WatchResult) {
+				t.Errorf("OnUpdate must not be called for a killed command: %v\n%s", result.Err, result.Output)
+			},
+		})
+	}()
+
+	if got, want := <-lines, "Hello, Uwagaki (sync)!"; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+
+	// Edit the file without changing its size. The running program is killed and run again.
+	content := strings.Replace(string(patch), "Hello", "Howdy", 1)
+	if err := os.WriteFile(filepath.Join(patchDir, "additional_file_by_uwagaki.go"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := <-lines, "Howdy, Uwagaki (sync)!"; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+
+	cancel()
+	select {
+	case err := <-watchErr:
+		if err != context.Canceled {
+			t.Error(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Watch must return after the context is done")
+	}
+}
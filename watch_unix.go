@@ -0,0 +1,23 @@
+// SPDX-License-Identifier: Apache-2.0
+// SPDX-FileCopyrightText: 2025 Hajime Hoshi
+
+//go:build unix
+
+package uwagaki
+
+import (
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// killProcessGroupOnCancel makes cmd kill its process group when its context is done,
+// so that a program run by 'go run' is also killed.
+func killProcessGroupOnCancel(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	// A grandchild process might keep the output pipe open.
+	cmd.WaitDelay = time.Second
+}